@@ -0,0 +1,447 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server.
+// It is loaded by LoadConfig with the precedence of flags > environment variables > config file > defaults.
+type Config struct {
+	// Port is the port number to listen on.
+	Port string
+	// ImageStore is the backend storing images, "local" or "s3".
+	ImageStore string
+	// ImageDirPath is the path to the directory storing images in the local image store.
+	ImageDirPath string
+	// S3 is the configuration of the s3 image store.
+	S3 S3Config
+	// DBPath is the path to the SQLite database file.
+	DBPath string
+	// CORSOrigins are the origins allowed to access the server from browsers.
+	CORSOrigins []string
+	LogLevel    slog.Level
+	// ReadTimeout is the maximum duration for reading an entire request including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of a response.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum duration to wait for the next request on a keep-alive connection.
+	IdleTimeout time.Duration
+	// ShutdownDelay is the duration to keep serving with failing readiness after a shutdown signal,
+	// so that load balancers stop routing new requests before the listener is closed.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is the maximum duration to drain in-flight requests on shutdown.
+	ShutdownTimeout time.Duration
+	// AccessTokenTTL is the lifetime of an access token issued on login or refresh.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is the lifetime of a refresh token, after which the user has to log in again.
+	RefreshTokenTTL time.Duration
+	// ImageLimits are the limits of uploaded images.
+	ImageLimits ImageLimits
+	// StrictCategories rejects items with unknown categories instead of creating the categories.
+	StrictCategories bool
+	// ImageGCInterval is the interval of deleting the images no item refers to in the background.
+	// The garbage collection runs only by the gc-images command if it is zero.
+	ImageGCInterval time.Duration
+	// ImageGCMinAge is the age under which images are never deleted by the garbage collection,
+	// so that images uploaded for items being created are not deleted before the items are saved.
+	ImageGCMinAge time.Duration
+	// CheckImagesOnStartup verifies the stored images against their hashes in the background on startup,
+	// and quarantines the corrupted ones.
+	CheckImagesOnStartup bool
+}
+
+// DefaultConfig returns the configuration used when nothing is specified.
+func DefaultConfig() Config {
+	return Config{
+		Port:         "9001",
+		ImageStore:   "local",
+		ImageDirPath: "images",
+		DBPath:       "./db/merucari.sqlite3",
+		CORSOrigins:  []string{"http://localhost:3000"},
+		LogLevel:     slog.LevelInfo,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+
+		ShutdownTimeout: 30 * time.Second,
+
+		ImageLimits: ImageLimits{MaxBytes: 10 << 20, MaxWidth: 8192, MaxHeight: 8192},
+
+		ImageGCMinAge: time.Hour,
+
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// configOption is a setting which can be specified by a flag, an environment variable and a key of the config file.
+type configOption struct {
+	// name is the name of the flag and the key in the config file.
+	name  string
+	env   string
+	usage string
+	// isBool makes the flag take no value, such as -strict-categories.
+	isBool bool
+	set    func(c *Config, v string) error
+}
+
+var configOptions = []configOption{
+	{
+		name: "port", env: "PORT", usage: "port number to listen on",
+		set: func(c *Config, v string) error { c.Port = v; return nil },
+	},
+	{
+		name: "image-dir", env: "IMAGE_DIR", usage: "directory storing images",
+		set: func(c *Config, v string) error { c.ImageDirPath = v; return nil },
+	},
+	{
+		name: "image-store", env: "IMAGE_STORE", usage: "backend storing images (local or s3)",
+		set: func(c *Config, v string) error { c.ImageStore = v; return nil },
+	},
+	{
+		name: "s3-endpoint", env: "S3_ENDPOINT", usage: "base URL of the S3-compatible storage",
+		set: func(c *Config, v string) error { c.S3.Endpoint = v; return nil },
+	},
+	{
+		name: "s3-region", env: "S3_REGION", usage: "region of the S3 bucket",
+		set: func(c *Config, v string) error { c.S3.Region = v; return nil },
+	},
+	{
+		name: "s3-bucket", env: "S3_BUCKET", usage: "S3 bucket storing images",
+		set: func(c *Config, v string) error { c.S3.Bucket = v; return nil },
+	},
+	{
+		name: "s3-prefix", env: "S3_PREFIX", usage: "prefix of the S3 object keys, such as images/",
+		set: func(c *Config, v string) error { c.S3.Prefix = v; return nil },
+	},
+	{
+		name: "s3-access-key-id", env: "S3_ACCESS_KEY_ID", usage: "access key ID of the S3 storage",
+		set: func(c *Config, v string) error { c.S3.AccessKeyID = v; return nil },
+	},
+	{
+		name: "s3-secret-access-key", env: "S3_SECRET_ACCESS_KEY", usage: "secret access key of the S3 storage",
+		set: func(c *Config, v string) error { c.S3.SecretAccessKey = v; return nil },
+	},
+	{
+		name: "s3-public-url", env: "S3_PUBLIC_URL", usage: "base URL serving the S3 objects to clients (served through the API if empty)",
+		set: func(c *Config, v string) error { c.S3.PublicURL = v; return nil },
+	},
+	{
+		name: "db-path", env: "DB_PATH", usage: "path to the SQLite database file",
+		set: func(c *Config, v string) error { c.DBPath = v; return nil },
+	},
+	{
+		name: "cors-origins", env: "CORS_ORIGINS", usage: "comma-separated origins allowed by CORS",
+		set: func(c *Config, v string) error { c.CORSOrigins = splitList(v); return nil },
+	},
+	{
+		name: "log-level", env: "LOG_LEVEL", usage: "log level (debug, info, warn or error)",
+		set: func(c *Config, v string) error { return c.LogLevel.UnmarshalText([]byte(v)) },
+	},
+	{
+		name: "read-timeout", env: "READ_TIMEOUT", usage: "timeout for reading a request, such as 30s",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	},
+	{
+		name: "write-timeout", env: "WRITE_TIMEOUT", usage: "timeout for writing a response, such as 30s",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	},
+	{
+		name: "idle-timeout", env: "IDLE_TIMEOUT", usage: "timeout for idle keep-alive connections, such as 2m",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	},
+	{
+		name: "shutdown-delay", env: "SHUTDOWN_DELAY", usage: "time to fail readiness before shutting down, such as 5s",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.ShutdownDelay }),
+	},
+	{
+		name: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "timeout for draining requests on shutdown, such as 30s",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	},
+	{
+		name: "image-max-bytes", env: "IMAGE_MAX_BYTES", usage: "maximum size of an uploaded image in bytes",
+		set: intSetter(func(c *Config) *int64 { return &c.ImageLimits.MaxBytes }),
+	},
+	{
+		name: "image-max-width", env: "IMAGE_MAX_WIDTH", usage: "maximum width of an uploaded image in pixels",
+		set: intSetter(func(c *Config) *int { return &c.ImageLimits.MaxWidth }),
+	},
+	{
+		name: "image-max-height", env: "IMAGE_MAX_HEIGHT", usage: "maximum height of an uploaded image in pixels",
+		set: intSetter(func(c *Config) *int { return &c.ImageLimits.MaxHeight }),
+	},
+	{
+		name: "image-gc-interval", env: "IMAGE_GC_INTERVAL", usage: "interval of deleting unreferenced images in the background, such as 24h (disabled if 0)",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.ImageGCInterval }),
+	},
+	{
+		name: "image-gc-min-age", env: "IMAGE_GC_MIN_AGE", usage: "minimum age of unreferenced images to be deleted, such as 1h",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.ImageGCMinAge }),
+	},
+	{
+		name: "check-images-on-startup", env: "CHECK_IMAGES_ON_STARTUP", usage: "verify the stored images and quarantine the corrupted ones on startup", isBool: true,
+		set: func(c *Config, v string) (err error) { c.CheckImagesOnStartup, err = strconv.ParseBool(v); return err },
+	},
+	{
+		name: "access-token-ttl", env: "ACCESS_TOKEN_TTL", usage: "lifetime of an access token, such as 15m",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.AccessTokenTTL }),
+	},
+	{
+		name: "refresh-token-ttl", env: "REFRESH_TOKEN_TTL", usage: "lifetime of a refresh token, such as 720h",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.RefreshTokenTTL }),
+	},
+	{
+		name: "strict-categories", env: "STRICT_CATEGORIES", usage: "reject items with unknown categories", isBool: true,
+		set: func(c *Config, v string) (err error) { c.StrictCategories, err = strconv.ParseBool(v); return err },
+	},
+}
+
+func durationSetter(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func intSetter[T int | int64](field func(c *Config) *T) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = T(n)
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// LoadConfig loads the configuration from the command line arguments, the environment variables
+// and the YAML config file specified by -config or CONFIG_FILE, and validates it with validate,
+// such as (*Config).Validate for the server. It returns the arguments remaining after the flags.
+func LoadConfig(args []string, getenv func(string) string, validate func(*Config) error) (*Config, []string, error) {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "path to the YAML config file")
+	flagValues := map[string]string{}
+	for _, opt := range configOptions {
+		usage := fmt.Sprintf("%s (env %s)", opt.usage, opt.env)
+		set := func(v string) error {
+			flagValues[opt.name] = v
+			return nil
+		}
+		if opt.isBool {
+			fs.BoolFunc(opt.name, usage, set)
+		} else {
+			fs.Func(opt.name, usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	cfg := DefaultConfig()
+	if *configFile != "" {
+		if err := loadConfigFile(&cfg, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, opt := range configOptions {
+		v := getenv(opt.env)
+		// FRONT_URL is the former name of CORS_ORIGINS
+		if v == "" && opt.env == "CORS_ORIGINS" {
+			v = getenv("FRONT_URL")
+		}
+		if v == "" {
+			continue
+		}
+		if err := opt.set(&cfg, v); err != nil {
+			return nil, nil, fmt.Errorf("invalid environment variable %s=%q: %w", opt.env, v, err)
+		}
+	}
+
+	for _, opt := range configOptions {
+		v, ok := flagValues[opt.name]
+		if !ok {
+			continue
+		}
+		if err := opt.set(&cfg, v); err != nil {
+			return nil, nil, fmt.Errorf("invalid flag -%s=%q: %w", opt.name, v, err)
+		}
+	}
+
+	if err := validate(&cfg); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, fs.Args(), nil
+}
+
+// loadConfigFile overwrites cfg with the values in the YAML config file.
+// Keys are the flag names, and lists can be written either as YAML sequences or comma-separated strings.
+func loadConfigFile(cfg *Config, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		i := slices.IndexFunc(configOptions, func(opt configOption) bool { return opt.name == key })
+		if i < 0 {
+			return fmt.Errorf("unknown key %q in config file %s", key, path)
+		}
+
+		var v string
+		switch value := value.(type) {
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			v = strings.Join(items, ",")
+		case nil:
+			continue
+		default:
+			v = fmt.Sprint(value)
+		}
+		if err := configOptions[i].set(cfg, v); err != nil {
+			return fmt.Errorf("invalid value %q for %s in config file %s: %w", v, key, path, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable by the server and reports all the problems found.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number between 1 and 65535: %q", c.Port))
+	}
+
+	errs = append(errs, c.imageStoreErrors()...)
+	errs = append(errs, c.dbErrors()...)
+
+	if len(c.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("at least one CORS origin is required"))
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("CORS origin must be * or scheme://host[:port]: %q", origin))
+		}
+	}
+
+	for _, timeout := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"idle timeout", c.IdleTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+		{"image gc min age", c.ImageGCMinAge},
+		{"access token ttl", c.AccessTokenTTL},
+		{"refresh token ttl", c.RefreshTokenTTL},
+	} {
+		if timeout.d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive: %s", timeout.name, timeout.d))
+		}
+	}
+
+	if c.ImageLimits.MaxBytes <= 0 || c.ImageLimits.MaxWidth <= 0 || c.ImageLimits.MaxHeight <= 0 {
+		errs = append(errs, fmt.Errorf("image limits must be positive: %+v", c.ImageLimits))
+	}
+
+	if c.ShutdownDelay < 0 {
+		errs = append(errs, fmt.Errorf("shutdown delay must not be negative: %s", c.ShutdownDelay))
+	}
+	if c.ImageGCInterval < 0 {
+		errs = append(errs, fmt.Errorf("image gc interval must not be negative: %s", c.ImageGCInterval))
+	}
+
+	return joinConfigErrors(errs)
+}
+
+// ValidateDB checks only the database settings, for the subcommands which do not touch the images
+// such as migrate and set-role.
+func (c *Config) ValidateDB() error {
+	return joinConfigErrors(c.dbErrors())
+}
+
+// ValidateImages checks the database and the image store settings, for the subcommands
+// maintaining the stored images such as gc-images and check-images.
+func (c *Config) ValidateImages() error {
+	errs := append(c.imageStoreErrors(), c.dbErrors()...)
+	if c.ImageGCMinAge <= 0 {
+		errs = append(errs, fmt.Errorf("image gc min age must be positive: %s", c.ImageGCMinAge))
+	}
+	return joinConfigErrors(errs)
+}
+
+func (c *Config) imageStoreErrors() []error {
+	var errs []error
+	switch c.ImageStore {
+	case "local":
+		if info, err := os.Stat(c.ImageDirPath); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("image directory does not exist: %q", c.ImageDirPath))
+		}
+	case "s3":
+		if u, err := url.Parse(c.S3.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("S3 endpoint must be an absolute URL: %q", c.S3.Endpoint))
+		}
+		if c.S3.Bucket == "" {
+			errs = append(errs, errors.New("S3 bucket is required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("image store must be local or s3: %q", c.ImageStore))
+	}
+	return errs
+}
+
+func (c *Config) dbErrors() []error {
+	if c.DBPath == "" {
+		return []error{errors.New("db path is required")}
+	}
+	if info, err := os.Stat(filepath.Dir(c.DBPath)); err != nil || !info.IsDir() {
+		return []error{fmt.Errorf("directory of the db path does not exist: %q", c.DBPath)}
+	}
+	return nil
+}
+
+func joinConfigErrors(errs []error) error {
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
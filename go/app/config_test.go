@@ -0,0 +1,247 @@
+package app
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	imageDir := filepath.Join(dir, "images")
+	if err := os.Mkdir(imageDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.yaml")
+	config := `
+port: 8000
+image-dir: ` + imageDir + `
+db-path: ` + filepath.Join(dir, "file.sqlite3") + `
+cors-origins:
+  - https://example.com
+  - https://www.example.com
+log-level: warn
+read-timeout: 10s
+`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := filepath.Join(dir, "mercari.sqlite3")
+	base := DefaultConfig()
+	base.ImageDirPath = imageDir
+	base.DBPath = dbPath
+
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		want func() Config
+		rest []string
+	}{
+		"defaults": {
+			env: map[string]string{"IMAGE_DIR": imageDir, "DB_PATH": dbPath},
+			want: func() Config {
+				return base
+			},
+		},
+		"config file": {
+			args: []string{"-config", configFile},
+			want: func() Config {
+				c := base
+				c.Port = "8000"
+				c.DBPath = filepath.Join(dir, "file.sqlite3")
+				c.CORSOrigins = []string{"https://example.com", "https://www.example.com"}
+				c.LogLevel = slog.LevelWarn
+				c.ReadTimeout = 10 * time.Second
+				return c
+			},
+		},
+		"env overrides config file": {
+			env: map[string]string{
+				"CONFIG_FILE":       configFile,
+				"PORT":              "8001",
+				"CORS_ORIGINS":      "https://env.example.com",
+				"IMAGE_GC_INTERVAL": "24h",
+				"ACCESS_TOKEN_TTL":  "5m",
+
+				"CHECK_IMAGES_ON_STARTUP": "true",
+			},
+			want: func() Config {
+				c := base
+				c.Port = "8001"
+				c.DBPath = filepath.Join(dir, "file.sqlite3")
+				c.CORSOrigins = []string{"https://env.example.com"}
+				c.LogLevel = slog.LevelWarn
+				c.ReadTimeout = 10 * time.Second
+				c.ImageGCInterval = 24 * time.Hour
+				c.AccessTokenTTL = 5 * time.Minute
+				c.CheckImagesOnStartup = true
+				return c
+			},
+		},
+		"flags override env": {
+			args: []string{"-config", configFile, "-port", "8002", "-log-level", "debug", "-strict-categories", "status"},
+			env: map[string]string{
+				"PORT":              "8001",
+				"STRICT_CATEGORIES": "false",
+			},
+			want: func() Config {
+				c := base
+				c.Port = "8002"
+				c.DBPath = filepath.Join(dir, "file.sqlite3")
+				c.CORSOrigins = []string{"https://example.com", "https://www.example.com"}
+				c.LogLevel = slog.LevelDebug
+				c.ReadTimeout = 10 * time.Second
+				c.StrictCategories = true
+				return c
+			},
+			rest: []string{"status"},
+		},
+		"FRONT_URL is an alias of CORS_ORIGINS": {
+			env: map[string]string{
+				"IMAGE_DIR": imageDir,
+				"DB_PATH":   dbPath,
+				"FRONT_URL": "https://front.example.com",
+			},
+			want: func() Config {
+				c := base
+				c.CORSOrigins = []string{"https://front.example.com"}
+				return c
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, rest, err := LoadConfig(tt.args, func(key string) string { return tt.env[key] }, (*Config).Validate)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want(), *got); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.rest, rest, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected rest arguments (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	unknownKey := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(unknownKey, []byte("prot: 9001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		"invalid duration in env": {
+			env:  map[string]string{"IMAGE_DIR": dir, "READ_TIMEOUT": "ten seconds"},
+			want: []string{"READ_TIMEOUT"},
+		},
+		"invalid log level flag": {
+			args: []string{"-image-dir", dir, "-log-level", "verbose"},
+			want: []string{"-log-level"},
+		},
+		"unknown key in config file": {
+			args: []string{"-image-dir", dir, "-config", unknownKey},
+			want: []string{`unknown key "prot"`},
+		},
+		"s3 image store without a bucket": {
+			args: []string{"-image-store", "s3", "-s3-endpoint", "localhost:9000"},
+			want: []string{"S3 endpoint", "S3 bucket"},
+		},
+		"all validation errors are reported": {
+			args: []string{"-port", "0", "-image-dir", filepath.Join(dir, "missing"), "-cors-origins", "example.com", "-idle-timeout", "0s", "-shutdown-delay", "-1s", "-image-gc-interval", "-1h", "-refresh-token-ttl", "0s"},
+			want: []string{"port", "image directory", "CORS origin", "idle timeout", "shutdown delay", "image gc interval", "refresh token ttl"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := LoadConfig(tt.args, func(key string) string { return tt.env[key] }, (*Config).Validate)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected the error to mention %q, got %q", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigValidateCommands(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// the image directory is missing, which only matters to the commands using the images
+	missingImages := DefaultConfig()
+	missingImages.ImageDirPath = filepath.Join(dir, "missing")
+	missingImages.DBPath = filepath.Join(dir, "mercari.sqlite3")
+	missingDB := missingImages
+	missingDB.DBPath = filepath.Join(dir, "missing", "mercari.sqlite3")
+
+	cases := map[string]struct {
+		cfg      Config
+		validate func(*Config) error
+		// want is the part of the error message. It is empty if the config is valid.
+		want string
+	}{
+		"ok: db without images": {
+			cfg:      missingImages,
+			validate: (*Config).ValidateDB,
+		},
+		"ng: db": {
+			cfg:      missingDB,
+			validate: (*Config).ValidateDB,
+			want:     "directory of the db path",
+		},
+		"ng: images": {
+			cfg:      missingImages,
+			validate: (*Config).ValidateImages,
+			want:     "image directory",
+		},
+		"ng: server": {
+			cfg:      missingImages,
+			validate: (*Config).Validate,
+			want:     "image directory",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.validate(&tt.cfg)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected the error to mention %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Error codes in error responses. Clients should branch on them instead of the messages.
+const (
+	codeInvalidRequest     = "invalid_request"
+	codeValidationFailed   = "validation_failed"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeInvalidCredentials = "invalid_credentials"
+	codeInvalidToken       = "invalid_token"
+	codeUnknownRole        = "unknown_role"
+	codeUserNotFound       = "user_not_found"
+	codeUserExists         = "user_exists"
+	codeItemNotFound       = "item_not_found"
+	codeItemImageNotFound  = "item_image_not_found"
+	codeImageNotFound      = "image_not_found"
+	codeCategoryNotFound   = "category_not_found"
+	codeUnknownCategory    = "unknown_category"
+	codeParentNotFound     = "parent_category_not_found"
+	codeCategoryExists     = "category_exists"
+	codeCategoryInUse      = "category_in_use"
+	codeCategoryCycle      = "category_cycle"
+	codeInvalidCursor      = "invalid_cursor"
+	codeEmptySearchQuery   = "empty_search_query"
+	codeTooManyImages      = "too_many_images"
+	codeInvalidImageOrder  = "invalid_image_order"
+	codeInvalidImageName   = "invalid_image_name"
+	codeUnsupportedImage   = "unsupported_image"
+	codeImageTooLarge      = "image_too_large"
+	codeImageDimensions    = "invalid_image_dimensions"
+	codeRequestTooLarge    = "request_too_large"
+	codeInternalError      = "internal_error"
+)
+
+// errInternal is the response to internal errors, which does not tell their causes.
+var errInternal = &APIError{Status: http.StatusInternalServerError, Code: codeInternalError, Message: "internal server error"}
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength is the maximum length of the request ids set by clients.
+	maxRequestIDLength = 64
+)
+
+// errorStatuses maps the sentinel errors to the statuses and the codes of their responses.
+// Errors with a status of 5xx are internal, and their messages are never shown to clients.
+var errorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{errItemNotFound, http.StatusNotFound, codeItemNotFound},
+	{errItemImageNotFound, http.StatusNotFound, codeItemImageNotFound},
+	{errImageNotFound, http.StatusNotFound, codeImageNotFound},
+	{errCategoryNotFound, http.StatusNotFound, codeCategoryNotFound},
+	{errParentNotFound, http.StatusBadRequest, codeParentNotFound},
+	{errCategoryExists, http.StatusConflict, codeCategoryExists},
+	{errCategoryInUse, http.StatusConflict, codeCategoryInUse},
+	{errCategoryCycle, http.StatusConflict, codeCategoryCycle},
+	{errInvalidCursor, http.StatusBadRequest, codeInvalidCursor},
+	{errEmptySearchQuery, http.StatusBadRequest, codeEmptySearchQuery},
+	{errTooManyImages, http.StatusBadRequest, codeTooManyImages},
+	{errInvalidImageOrder, http.StatusBadRequest, codeInvalidImageOrder},
+	{errInvalidImageName, http.StatusBadRequest, codeInvalidImageName},
+	{errUnsupportedImage, http.StatusUnsupportedMediaType, codeUnsupportedImage},
+	{errImageTooLarge, http.StatusRequestEntityTooLarge, codeImageTooLarge},
+	{errImageDimensions, http.StatusUnprocessableEntity, codeImageDimensions},
+	{errRequestTooLarge, http.StatusRequestEntityTooLarge, codeRequestTooLarge},
+	{errUserNotFound, http.StatusNotFound, codeUserNotFound},
+	{errUserExists, http.StatusConflict, codeUserExists},
+	{errInvalidCredentials, http.StatusUnauthorized, codeInvalidCredentials},
+	{errInvalidToken, http.StatusUnauthorized, codeInvalidToken},
+	{errUnauthenticated, http.StatusUnauthorized, codeUnauthorized},
+	{errNotItemSeller, http.StatusForbidden, codeForbidden},
+	{errPermissionDenied, http.StatusForbidden, codeForbidden},
+	{errUnknownRole, http.StatusBadRequest, codeUnknownRole},
+	{errUploadStorage, http.StatusInternalServerError, codeInternalError},
+}
+
+// ErrorResponse is the body of error responses in JSON.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details are additional information depending on the code.
+	Details any `json:"details,omitempty"`
+	// RequestID is the id of the request in the server logs.
+	RequestID string `json:"request_id"`
+}
+
+// APIError is an error with the response to it. Its message is shown to clients.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// requestError is an error caused by an invalid request, such as a malformed parameter.
+// Its message is shown to clients.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// invalidRequest marks err as caused by the request, which is responded with 400 unless err has
+// a status of its own, such as errImageTooLarge.
+func invalidRequest(err error) error {
+	return &requestError{err: err}
+}
+
+// toAPIError returns the response to err. The errors which are neither an APIError, a ValidationError,
+// a sentinel error in errorStatuses, nor an invalid request are internal.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    codeValidationFailed,
+			Message: validationErr.Error(),
+			Details: validationErr.Errors,
+		}
+	}
+	for _, s := range errorStatuses {
+		if !errors.Is(err, s.err) {
+			continue
+		}
+		if s.status >= http.StatusInternalServerError {
+			return errInternal
+		}
+		msg := s.err.Error()
+		// errors such as "image is too large: exceeds 1024 bytes" add details for clients to the sentinel,
+		// while the context added in front of it by the callers is internal
+		if strings.HasPrefix(err.Error(), msg) {
+			msg = err.Error()
+		}
+		return &APIError{Status: s.status, Code: s.code, Message: msg}
+	}
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return &APIError{Status: http.StatusBadRequest, Code: codeInvalidRequest, Message: reqErr.Error()}
+	}
+	return errInternal
+}
+
+// writeError responds with err in the JSON error envelope. Internal errors are logged with the request id,
+// and responded with a generic message.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	requestID := requestIDFromContext(r.Context())
+	if apiErr.Status >= http.StatusInternalServerError {
+		slog.Error("failed to handle request", "method", r.Method, "path", r.URL.Path, "request_id", requestID, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if apiErr.Status == http.StatusRequestEntityTooLarge {
+		// the rest of the body is not read, so the connection cannot be reused
+		w.Header().Set("Connection", "close")
+	}
+	w.WriteHeader(apiErr.Status)
+	resp := ErrorResponse{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Details:   apiErr.Details,
+		RequestID: requestID,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the id of the request set by requestIDMiddleware.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware identifies each request by the X-Request-ID header set by a proxy in front of the server,
+// or by a new random id, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether id is safe to be written to the logs and the responses.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err    error
+		status int
+		want   ErrorResponse
+	}{
+		"ng: sentinel error": {
+			err:    fmt.Errorf("failed to get item: %w", errItemNotFound),
+			status: http.StatusNotFound,
+			want:   ErrorResponse{Code: codeItemNotFound, Message: "item not found", RequestID: "req-1"},
+		},
+		"ng: sentinel error with details": {
+			err:    fmt.Errorf("%w: exceeds 1024 bytes", errImageTooLarge),
+			status: http.StatusRequestEntityTooLarge,
+			want:   ErrorResponse{Code: codeImageTooLarge, Message: "image is too large: exceeds 1024 bytes", RequestID: "req-1"},
+		},
+		"ng: invalid request": {
+			err:    invalidRequest(errors.New("name is required")),
+			status: http.StatusBadRequest,
+			want:   ErrorResponse{Code: codeInvalidRequest, Message: "name is required", RequestID: "req-1"},
+		},
+		"ng: invalid request with a status of its own": {
+			err:    invalidRequest(errTooManyImages),
+			status: http.StatusBadRequest,
+			want:   ErrorResponse{Code: codeTooManyImages, Message: errTooManyImages.Error(), RequestID: "req-1"},
+		},
+		"ng: validation error": {
+			err:    invalidRequest(&ValidationError{Errors: []FieldError{{Field: "name", Code: fieldRequired, Message: "name is required"}}}),
+			status: http.StatusUnprocessableEntity,
+			want: ErrorResponse{
+				Code:      codeValidationFailed,
+				Message:   "name is required",
+				Details:   []any{map[string]any{"field": "name", "code": fieldRequired, "message": "name is required"}},
+				RequestID: "req-1",
+			},
+		},
+		"ng: api error": {
+			err:    fmt.Errorf("failed to get category: %w", &APIError{Status: http.StatusBadRequest, Code: codeUnknownCategory, Message: "unknown category: phone"}),
+			status: http.StatusBadRequest,
+			want:   ErrorResponse{Code: codeUnknownCategory, Message: "unknown category: phone", RequestID: "req-1"},
+		},
+		"ng: internal error is not echoed": {
+			err:    fmt.Errorf("failed to store item: %w", errors.New("SQL logic error: no such table: items")),
+			status: http.StatusInternalServerError,
+			want:   ErrorResponse{Code: codeInternalError, Message: "internal server error", RequestID: "req-1"},
+		},
+		"ng: internal error in an invalid request": {
+			err:    invalidRequest(fmt.Errorf("%w: disk full", errUploadStorage)),
+			status: http.StatusInternalServerError,
+			want:   ErrorResponse{Code: codeInternalError, Message: "internal server error", RequestID: "req-1"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/items/1", nil)
+			req.Header.Set(requestIDHeader, "req-1")
+			rr := httptest.NewRecorder()
+			requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, tt.err)
+			})).ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("expected status code %d, got %d", tt.status, rr.Code)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("unexpected content type %q", got)
+			}
+			var got ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected response (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		header string
+		// wantSame reports whether the request id set by the client is used.
+		wantSame bool
+	}{
+		"ok: request id from the proxy": {
+			header:   "0b7c6d2e-1f3a-4c5d-9e8f-7a6b5c4d3e2f",
+			wantSame: true,
+		},
+		"ok: no request id": {},
+		"ng: unsafe request id": {
+			header: "id\nforged log line",
+		},
+		"ng: too long request id": {
+			header: strings.Repeat("a", maxRequestIDLength+1),
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = requestIDFromContext(r.Context())
+			})
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			requestIDMiddleware(next).ServeHTTP(rr, req)
+
+			if got == "" || !validRequestID(got) {
+				t.Fatalf("expected a valid request id, got %q", got)
+			}
+			if (got == tt.header) != tt.wantSame {
+				t.Errorf("expected the request id from the header to be used: %v, got %q", tt.wantSame, got)
+			}
+			if h := rr.Header().Get(requestIDHeader); h != got {
+				t.Errorf("expected the response header %q, got %q", got, h)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	// register the decoders of the supported formats to the image package
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	errUnsupportedImage = errors.New("unsupported image format")
+	errImageTooLarge    = errors.New("image is too large")
+	errImageDimensions  = errors.New("image dimensions are out of range")
+)
+
+// ImageLimits are the limits of uploaded images. A zero value means no limit.
+type ImageLimits struct {
+	// MaxBytes is the maximum size of an image file in bytes.
+	MaxBytes int64
+	// MaxWidth and MaxHeight are the maximum dimensions of an image in pixels.
+	MaxWidth  int
+	MaxHeight int
+}
+
+// ImageFormat is a supported image format.
+type ImageFormat struct {
+	// Name is the name registered to the image package, such as "jpeg".
+	Name        string
+	ContentType string
+	// Ext is the extension of the stored files including the dot.
+	Ext string
+}
+
+// imageFormats are the supported formats keyed by the content type detected from the magic bytes.
+var imageFormats = map[string]ImageFormat{
+	"image/jpeg": {Name: "jpeg", ContentType: "image/jpeg", Ext: ".jpg"},
+	"image/png":  {Name: "png", ContentType: "image/png", Ext: ".png"},
+	"image/gif":  {Name: "gif", ContentType: "image/gif", Ext: ".gif"},
+	"image/webp": {Name: "webp", ContentType: "image/webp", Ext: ".webp"},
+}
+
+// isImageExt reports whether ext is an extension of a supported image format.
+// .jpeg is accepted for images stored before the extensions were normalized.
+func isImageExt(ext string) bool {
+	if ext == ".jpeg" {
+		return true
+	}
+	for _, f := range imageFormats {
+		if f.Ext == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// detectImageFormat identifies the format of the image of size bytes read from r by its magic bytes,
+// and checks its size and dimensions against limits without decoding the whole image.
+func detectImageFormat(r io.ReadSeeker, size int64, limits ImageLimits) (ImageFormat, error) {
+	if limits.MaxBytes > 0 && size > limits.MaxBytes {
+		return ImageFormat{}, fmt.Errorf("%w: %d bytes exceeds %d bytes", errImageTooLarge, size, limits.MaxBytes)
+	}
+
+	// http.DetectContentType considers at most the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return ImageFormat{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	contentType := http.DetectContentType(head[:n])
+	format, ok := imageFormats[contentType]
+	if !ok {
+		return ImageFormat{}, fmt.Errorf("%w: %s", errUnsupportedImage, contentType)
+	}
+
+	// check the dimensions from the header before decoding the whole image,
+	// so that a small file claiming huge dimensions does not exhaust the memory
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return ImageFormat{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	cfg, name, err := image.DecodeConfig(r)
+	if err != nil || name != format.Name {
+		return ImageFormat{}, fmt.Errorf("%w: broken %s image", errUnsupportedImage, format.Name)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 ||
+		(limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth) ||
+		(limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight) {
+		return ImageFormat{}, fmt.Errorf("%w: %dx%d is not within %dx%d", errImageDimensions, cfg.Width, cfg.Height, limits.MaxWidth, limits.MaxHeight)
+	}
+	return format, nil
+}
+
+// variantWidths are the widths of the resized variants of images in ascending order.
+var variantWidths = []int{150, 400, 1024}
+
+// variantDir is the directory in the image store caching the variants.
+const variantDir = "variants"
+
+// nearestVariantWidth returns the smallest variant width which is not smaller than width.
+// It returns 0 if width is larger than all the variants, which means the original should be used.
+func nearestVariantWidth(width int) int {
+	for _, w := range variantWidths {
+		if w >= width {
+			return w
+		}
+	}
+	return 0
+}
+
+// variantName returns the name of the variant of the image resized to width.
+// Variants of JPEG images are encoded in JPEG, and the others in PNG to keep the transparency.
+func variantName(name string, width int) string {
+	ext := path.Ext(name)
+	variantExt := ".png"
+	if ext == ".jpg" || ext == ".jpeg" {
+		variantExt = ".jpg"
+	}
+	return fmt.Sprintf("%s/%s_w%d%s", variantDir, strings.TrimSuffix(name, ext), width, variantExt)
+}
+
+// isImmutableImageName reports whether the content stored as name never changes, which is true for
+// the uploaded images named by their hashes and their variants.
+func isImmutableImageName(name string) bool {
+	if variant, ok := strings.CutPrefix(name, variantDir+"/"); ok {
+		i := strings.LastIndex(variant, "_w")
+		if i < 0 {
+			return false
+		}
+		name = variant[:i] + path.Ext(variant)
+	}
+	_, ok := imageNameHash(name)
+	return ok
+}
+
+// imageVariant returns the name of the variant of the image nearest to width.
+// The variant is created and stored if it does not exist yet.
+func imageVariant(ctx context.Context, store ImageStore, name string, width int) (string, error) {
+	variantWidth := nearestVariantWidth(width)
+	if variantWidth == 0 {
+		return name, nil
+	}
+	variant := variantName(name, variantWidth)
+	if ok, err := store.Exists(ctx, variant); err != nil {
+		return "", err
+	} else if ok {
+		return variant, nil
+	}
+
+	img, err := store.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer img.Close()
+	src, _, err := image.Decode(img)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image %s: %w", name, err)
+	}
+	if err := writeVariant(ctx, store, variant, src, variantWidth); err != nil {
+		return "", err
+	}
+	return variant, nil
+}
+
+// writeVariant resizes src to width and stores it as variant.
+func writeVariant(ctx context.Context, store ImageStore, variant string, src image.Image, width int) error {
+	dst := resizeImage(src, width)
+	var b bytes.Buffer
+	var err error
+	if path.Ext(variant) == ".jpg" {
+		err = jpeg.Encode(&b, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&b, dst)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode variant: %w", err)
+	}
+	if err := store.Put(ctx, variant, &b); err != nil {
+		return fmt.Errorf("failed to store variant: %w", err)
+	}
+	return nil
+}
+
+// resizeImage scales src down to width keeping the aspect ratio. Each pixel of the result is
+// the average of the source pixels it covers. Images narrower than width are not enlarged.
+func resizeImage(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	width = min(width, sw)
+	height := max(1, sh*width/sw)
+	at := rgbaAt(src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		sy0, sy1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := range width {
+			sx0, sx1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := at(bounds.Min.X+sx, bounds.Min.Y+sy)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+				}
+			}
+			n := (sx1 - sx0) * (sy1 - sy0)
+			p := dst.Pix[dst.PixOffset(x, y):]
+			for i := range sum {
+				p[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// rgbaAt returns a function reading the pixels of src as color.RGBA. The images decoded from
+// JPEG and PNG are read directly so that the common formats avoid the color.Color allocation.
+func rgbaAt(src image.Image) func(x, y int) color.RGBA {
+	switch img := src.(type) {
+	case *image.RGBA:
+		return img.RGBAAt
+	case *image.NRGBA:
+		return func(x, y int) color.RGBA {
+			r, g, b, a := img.NRGBAAt(x, y).RGBA()
+			return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+		}
+	case *image.YCbCr:
+		return func(x, y int) color.RGBA {
+			c := img.YCbCrAt(x, y)
+			r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+			return color.RGBA{R: r, G: g, B: b, A: 0xff}
+		}
+	case *image.Gray:
+		return func(x, y int) color.RGBA {
+			v := img.GrayAt(x, y).Y
+			return color.RGBA{R: v, G: v, B: v, A: 0xff}
+		}
+	default:
+		return func(x, y int) color.RGBA {
+			r, g, b, a := src.At(x, y).RGBA()
+			return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// webpImage is a 1x1 lossless WebP image, which cannot be encoded with the standard library.
+var webpImage, _ = base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+
+// encodeTestImage encodes a width x height image in format, which is jpeg, png or gif.
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var b bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&b, img, nil)
+	case "png":
+		err = png.Encode(&b, img)
+	case "gif":
+		err = gif.Encode(&b, img, nil)
+	default:
+		t.Fatalf("unknown format: %s", format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDetectImageFormat(t *testing.T) {
+	t.Parallel()
+
+	limits := ImageLimits{MaxBytes: 1 << 20, MaxWidth: 64, MaxHeight: 32}
+	jpegImage := encodeTestImage(t, "jpeg", 16, 16)
+
+	cases := map[string]struct {
+		data    []byte
+		limits  ImageLimits
+		wantExt string
+		wantErr error
+	}{
+		"ok: jpeg": {
+			data:    jpegImage,
+			limits:  limits,
+			wantExt: ".jpg",
+		},
+		"ok: png": {
+			data:    encodeTestImage(t, "png", 64, 32),
+			limits:  limits,
+			wantExt: ".png",
+		},
+		"ok: gif": {
+			data:    encodeTestImage(t, "gif", 16, 16),
+			limits:  limits,
+			wantExt: ".gif",
+		},
+		"ok: webp": {
+			data:    webpImage,
+			limits:  limits,
+			wantExt: ".webp",
+		},
+		"ok: no limits": {
+			data:    encodeTestImage(t, "png", 128, 128),
+			wantExt: ".png",
+		},
+		"ng: text": {
+			data:    []byte("test image data"),
+			limits:  limits,
+			wantErr: errUnsupportedImage,
+		},
+		"ng: unsupported image format": {
+			data:    []byte("BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00"),
+			limits:  limits,
+			wantErr: errUnsupportedImage,
+		},
+		"ng: truncated header": {
+			data:    jpegImage[:20],
+			limits:  limits,
+			wantErr: errUnsupportedImage,
+		},
+		"ng: too large file": {
+			data:    jpegImage,
+			limits:  ImageLimits{MaxBytes: int64(len(jpegImage) - 1)},
+			wantErr: errImageTooLarge,
+		},
+		"ng: too wide": {
+			data:    encodeTestImage(t, "png", 65, 32),
+			limits:  limits,
+			wantErr: errImageDimensions,
+		},
+		"ng: too tall": {
+			data:    encodeTestImage(t, "png", 64, 33),
+			limits:  limits,
+			wantErr: errImageDimensions,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := detectImageFormat(bytes.NewReader(tt.data), int64(len(tt.data)), tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got.Ext != tt.wantExt {
+				t.Errorf("unexpected extension, want %q, got %q", tt.wantExt, got.Ext)
+			}
+		})
+	}
+}
+
+func TestNearestVariantWidth(t *testing.T) {
+	t.Parallel()
+
+	cases := map[int]int{
+		1:    150,
+		150:  150,
+		151:  400,
+		400:  400,
+		800:  1024,
+		1024: 1024,
+		1025: 0,
+	}
+	for width, want := range cases {
+		if got := nearestVariantWidth(width); got != want {
+			t.Errorf("nearestVariantWidth(%d) = %d, want %d", width, got, want)
+		}
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	t.Parallel()
+
+	// the left half is black and the right half is white
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := range 4 {
+		for y := range 2 {
+			c := color.RGBA{A: 255}
+			if x >= 2 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	cases := map[string]struct {
+		width      int
+		wantBounds image.Rectangle
+		wantPixels []color.RGBA
+	}{
+		"halve": {
+			width:      2,
+			wantBounds: image.Rect(0, 0, 2, 1),
+			wantPixels: []color.RGBA{{A: 255}, {R: 255, G: 255, B: 255, A: 255}},
+		},
+		"average across the halves": {
+			width:      1,
+			wantBounds: image.Rect(0, 0, 1, 1),
+			wantPixels: []color.RGBA{{R: 127, G: 127, B: 127, A: 255}},
+		},
+		"not enlarged": {
+			width:      10,
+			wantBounds: image.Rect(0, 0, 4, 2),
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := resizeImage(src, tt.width)
+			if got.Bounds() != tt.wantBounds {
+				t.Fatalf("unexpected bounds, want %v, got %v", tt.wantBounds, got.Bounds())
+			}
+			for x, want := range tt.wantPixels {
+				if c := got.RGBAAt(x, 0); c != want {
+					t.Errorf("unexpected pixel at %d, want %v, got %v", x, want, c)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeImageFormats(t *testing.T) {
+	t.Parallel()
+
+	// every source is filled with white and starts at a non-zero origin
+	rect := image.Rect(2, 2, 6, 4)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = 255
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = 128, 128
+	}
+	rgba, nrgba, gray := image.NewRGBA(rect), image.NewNRGBA(rect), image.NewGray(rect)
+	for _, img := range []draw.Image{rgba, nrgba, gray} {
+		draw.Draw(img, rect, image.White, image.Point{}, draw.Src)
+	}
+	cases := map[string]image.Image{
+		"rgba":     rgba,
+		"nrgba":    nrgba,
+		"gray":     gray,
+		"ycbcr":    ycbcr,
+		"paletted": image.NewPaletted(rect, color.Palette{color.White}),
+	}
+
+	for name, src := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := resizeImage(src, 2)
+			if want := image.Rect(0, 0, 2, 1); got.Bounds() != want {
+				t.Fatalf("unexpected bounds, want %v, got %v", want, got.Bounds())
+			}
+			for x := range 2 {
+				if c, want := got.RGBAAt(x, 0), (color.RGBA{R: 255, G: 255, B: 255, A: 255}); c != want {
+					t.Errorf("unexpected pixel at %d, want %v, got %v", x, want, c)
+				}
+			}
+		})
+	}
+}
+
+func TestImageVariant(t *testing.T) {
+	t.Parallel()
+
+	images := NewMemoryImageStore()
+	if err := images.Put(t.Context(), "photo.png", bytes.NewReader(encodeTestImage(t, "png", 1200, 600))); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		width      int
+		wantName   string
+		wantBounds image.Rectangle
+	}{
+		"nearest larger variant": {
+			width:      300,
+			wantName:   "variants/photo_w400.png",
+			wantBounds: image.Rect(0, 0, 400, 200),
+		},
+		"exact variant": {
+			width:      150,
+			wantName:   "variants/photo_w150.png",
+			wantBounds: image.Rect(0, 0, 150, 75),
+		},
+		"larger than all the variants": {
+			width:      2000,
+			wantName:   "photo.png",
+			wantBounds: image.Rect(0, 0, 1200, 600),
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// the second call returns the stored variant
+			for range 2 {
+				got, err := imageVariant(t.Context(), images, "photo.png", tt.width)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got != tt.wantName {
+					t.Fatalf("unexpected name, want %q, got %q", tt.wantName, got)
+				}
+			}
+
+			img, err := images.Get(t.Context(), tt.wantName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer img.Close()
+			cfg, _, err := image.DecodeConfig(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := image.Rect(0, 0, cfg.Width, cfg.Height); got != tt.wantBounds {
+				t.Errorf("unexpected bounds, want %v, got %v", tt.wantBounds, got)
+			}
+		})
+	}
+}
+
+func TestIsImmutableImageName(t *testing.T) {
+	t.Parallel()
+
+	hash := strings.Repeat("0123456789abcdef", 4)
+	cases := map[string]bool{
+		hash + ".jpg":                       true,
+		variantName(hash+".jpg", 150):       true,
+		variantName(hash+".webp", 400):      true,
+		"default.jpg":                       false,
+		variantName("default.jpg", 150):     false,
+		"variants/" + hash + ".jpg":         false,
+		quarantineDir + "/" + hash + ".jpg": false,
+	}
+
+	for name, want := range cases {
+		if got := isImmutableImageName(name); got != want {
+			t.Errorf("isImmutableImageName(%q): expected %v, got %v", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+var errImageCorrupted = errors.New("stored image does not match its hash")
+
+// quarantineDir is the directory in the image store where corrupted images are moved for inspection.
+const quarantineDir = "quarantine"
+
+// imageNameHash returns the hex-encoded SHA-256 hash in the name of an uploaded image, <hash><ext>.
+// It returns false for the other names, such as variants and images stored by old versions.
+func imageNameHash(name string) (string, bool) {
+	hash := strings.TrimSuffix(name, path.Ext(name))
+	if len(hash) != sha256.Size*2 || strings.Contains(hash, "/") {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return hash, true
+}
+
+// verifyImage checks that the content of the stored image matches the hash in its name.
+// Images without a hash in their names are not verified.
+func verifyImage(ctx context.Context, store ImageStore, name string) error {
+	want, ok := imageNameHash(name)
+	if !ok {
+		return nil
+	}
+	img, err := store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, img); err != nil {
+		return fmt.Errorf("failed to read image %s: %w", name, err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("%w: %s has hash %s", errImageCorrupted, name, got)
+	}
+	return nil
+}
+
+// ImageCheckResult is the result of an integrity check of the image store.
+type ImageCheckResult struct {
+	// Checked is the number of the verified images.
+	Checked int
+	// Corrupted are the names of the images which do not match their hashes.
+	Corrupted []string
+}
+
+// CheckImages verifies all the uploaded images in the store against their hashes, and moves the corrupted
+// ones to quarantineDir unless dryRun is true. The variants of the corrupted images are deleted, and are
+// created again from a new upload of the same image.
+// It continues on failures to check images, and returns them joined with the result.
+func CheckImages(ctx context.Context, store ImageStore, dryRun bool) (*ImageCheckResult, error) {
+	stored, err := store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	result := &ImageCheckResult{}
+	var errs []error
+	for _, img := range stored {
+		if _, ok := imageNameHash(img.Name); !ok {
+			continue
+		}
+		result.Checked++
+		err := verifyImage(ctx, store, img.Name)
+		switch {
+		case err == nil, errors.Is(err, errImageNotFound):
+			// the image is valid, or has been deleted since listed
+			continue
+		case !errors.Is(err, errImageCorrupted):
+			errs = append(errs, err)
+			continue
+		}
+
+		slog.Warn("found corrupted image", "error", err)
+		if !dryRun {
+			if err := quarantineImage(ctx, store, img.Name); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		result.Corrupted = append(result.Corrupted, img.Name)
+	}
+	return result, errors.Join(errs...)
+}
+
+// quarantineImage moves the image to quarantineDir and deletes its variants.
+func quarantineImage(ctx context.Context, store ImageStore, name string) error {
+	img, err := store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	err = store.Put(ctx, path.Join(quarantineDir, name), img)
+	img.Close()
+	if err != nil {
+		return fmt.Errorf("failed to quarantine image %s: %w", name, err)
+	}
+
+	var errs []error
+	for _, width := range variantWidths {
+		errs = append(errs, store.Delete(ctx, variantName(name, width)))
+	}
+	errs = append(errs, store.Delete(ctx, name))
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to delete corrupted image %s: %w", name, err)
+	}
+	return nil
+}
+
+// RunCheckImagesCommand runs the check-images command, which verifies the stored images against their hashes.
+// It returns the exit code, which is 1 if any image is corrupted.
+//
+//	check-images            moves the corrupted images to the quarantine directory
+//	check-images dry-run    lists the corrupted images without moving them
+func RunCheckImagesCommand(cfg Config, args []string, stdout io.Writer) int {
+	dryRun := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "dry-run":
+		dryRun = true
+	default:
+		fmt.Fprintln(stdout, "usage: check-images [dry-run]")
+		return 2
+	}
+
+	images, err := newImageStore(cfg)
+	if err != nil {
+		slog.Error("failed to set up image store", "error", err)
+		return 1
+	}
+
+	result, err := CheckImages(context.Background(), images, dryRun)
+	if result != nil {
+		verb := "quarantined"
+		if dryRun {
+			verb = "found"
+		}
+		for _, name := range result.Corrupted {
+			fmt.Fprintf(stdout, "%s %s\n", verb, name)
+		}
+		fmt.Fprintf(stdout, "checked %d images, %s %d corrupted images\n", result.Checked, verb, len(result.Corrupted))
+	}
+	if err != nil {
+		slog.Error("failed to check images", "error", err)
+		return 1
+	}
+	if len(result.Corrupted) > 0 {
+		return 1
+	}
+	return 0
+}
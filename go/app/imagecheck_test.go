@@ -0,0 +1,224 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// hashImageName returns the name of data stored by storeImage.
+func hashImageName(data []byte, ext string) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]) + ext
+}
+
+func TestImageNameHash(t *testing.T) {
+	t.Parallel()
+
+	hash := strings.Repeat("0123456789abcdef", 4)
+	cases := map[string]bool{
+		hash + ".jpg":                    true,
+		hash + ".png":                    true,
+		hash[:63] + ".jpg":               false,
+		strings.Repeat("g", 64) + ".jpg": false,
+		"variants/" + hash + "_w150.jpg": false,
+		"quarantine/" + hash + ".jpg":    false,
+		"default.jpg":                    false,
+	}
+
+	for name, want := range cases {
+		got, ok := imageNameHash(name)
+		if ok != want {
+			t.Errorf("imageNameHash(%q): expected %v, got %v", name, want, ok)
+		}
+		if ok && got != hash {
+			t.Errorf("imageNameHash(%q): unexpected hash %q", name, got)
+		}
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	t.Parallel()
+
+	valid := hashImageName([]byte("valid"), ".jpg")
+	corrupted := hashImageName([]byte("corrupted"), ".png")
+
+	cases := map[string]struct {
+		dryRun bool
+		// wantRemaining are the images left after the check.
+		wantRemaining []string
+	}{
+		"ok: corrupted images are quarantined": {
+			wantRemaining: []string{"default.jpg", "quarantine/" + corrupted, valid, variantName(valid, 150)},
+		},
+		"ok: dry run only reports corrupted images": {
+			dryRun:        true,
+			wantRemaining: []string{corrupted, "default.jpg", valid, variantName(corrupted, 150), variantName(valid, 150)},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			store := NewMemoryImageStore()
+			for name, data := range map[string]string{
+				valid:                       "valid",
+				variantName(valid, 150):     "variant",
+				corrupted:                   "corrupt",
+				variantName(corrupted, 150): "variant",
+				"default.jpg":               "default",
+			} {
+				if err := store.Put(ctx, name, strings.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := CheckImages(ctx, store, tt.dryRun)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := &ImageCheckResult{Checked: 2, Corrupted: []string{corrupted}}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected result (-want +got):\n%s", diff)
+			}
+
+			list, err := store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var remaining []string
+			for _, img := range list {
+				remaining = append(remaining, img.Name)
+			}
+			slices.Sort(remaining)
+			if diff := cmp.Diff(slices.Sorted(slices.Values(tt.wantRemaining)), remaining); diff != "" {
+				t.Errorf("unexpected remaining images (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// truncatingImageStore stores the first half of images, as a crash in the middle of a write does.
+type truncatingImageStore struct {
+	*MemoryImageStore
+}
+
+func (s truncatingImageStore) Put(ctx context.Context, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.MemoryImageStore.Put(ctx, name, bytes.NewReader(data[:len(data)/2]))
+}
+
+func TestAddItemCorruptedWrite(t *testing.T) {
+	t.Parallel()
+
+	store := truncatingImageStore{NewMemoryImageStore()}
+	h := &Handlers{images: store}
+
+	image := encodeTestImage(t, "png", 16, 16)
+	body, contentType := newMultipartForm(t, map[string]string{"name": "jacket", "category": "fashion", "price": "4800", "condition": "good"}, image)
+	req := httptest.NewRequest("POST", "/items", body)
+	req.Header.Set("Content-Type", contentType)
+	req = withUser(req, testSeller)
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if ok, err := store.Exists(t.Context(), hashImageName(image, ".png")); err != nil || ok {
+		t.Errorf("expected the corrupted image to be deleted, got %v, %v", ok, err)
+	}
+}
+
+func TestRunCheckImagesCommand(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultConfig()
+	cfg.ImageDirPath = t.TempDir()
+
+	image := encodeTestImage(t, "jpeg", 16, 16)
+	name := hashImageName(image, ".jpg")
+	// a write cut off by a crash
+	if err := os.WriteFile(filepath.Join(cfg.ImageDirPath, name), image[:len(image)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		args []string
+		code int
+		want string
+		// wantExists reports whether the image is left in place after the command.
+		wantExists bool
+	}{
+		{args: []string{"sideways"}, code: 2, want: "usage", wantExists: true},
+		{args: []string{"dry-run"}, code: 1, want: "found 1 corrupted images", wantExists: true},
+		{code: 1, want: "quarantined " + name},
+		{code: 0, want: "checked 0 images, quarantined 0 corrupted images"},
+	}
+
+	for _, tt := range cases {
+		var out bytes.Buffer
+		if code := RunCheckImagesCommand(cfg, tt.args, &out); code != tt.code {
+			t.Errorf("check-images %v: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("check-images %v: expected output to contain %q, got %q", tt.args, tt.want, out.String())
+		}
+		_, err := os.Stat(filepath.Join(cfg.ImageDirPath, name))
+		if exists := err == nil; exists != tt.wantExists {
+			t.Errorf("check-images %v: expected the image to exist: %v, got %v", tt.args, tt.wantExists, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.ImageDirPath, quarantineDir, name)); err != nil {
+		t.Errorf("expected the image to be quarantined: %v", err)
+	}
+}
+
+func TestVerifyImage(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	valid := hashImageName([]byte("valid"), ".jpg")
+	mismatched := hashImageName([]byte("other"), ".jpg")
+	store := NewMemoryImageStore()
+	for name, data := range map[string]string{
+		valid:        "valid",
+		mismatched:   "valid",
+		"legacy.jpg": "anything",
+	} {
+		if err := store.Put(ctx, name, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]error{
+		valid:                                    nil,
+		mismatched:                               errImageCorrupted,
+		"legacy.jpg":                             nil,
+		hashImageName([]byte("missing"), ".jpg"): errImageNotFound,
+	}
+
+	for name, want := range cases {
+		if err := verifyImage(ctx, store, name); !errors.Is(err, want) {
+			t.Errorf("verifyImage(%q): expected %v, got %v", name, want, err)
+		}
+	}
+}
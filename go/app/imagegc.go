@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageGC deletes the images which no item refers to with mark-and-sweep.
+//
+// Stored images are deduplicated by their hashes, so an image cannot be deleted with an item
+// because other items may share it. Instead, ImageGC marks the images referred to by items and
+// their variants, and sweeps the rest of the images in the store.
+//
+// An image is uploaded before the item referring to it is saved, so ImageGC never deletes images
+// younger than minAge. storeImage stores an image again even if it already exists to renew its
+// modification time, so that an old unreferenced image being reused is protected as well.
+// ImageGC checks the time and the references again under the lock of the image taken by
+// putUploadedImage, so that an upload in the same process never loses its image to the sweep.
+type ImageGC struct {
+	itemRepo ItemRepository
+	images   ImageStore
+	minAge   time.Duration
+	// now returns the current time to decide the age of images.
+	now func() time.Time
+}
+
+func NewImageGC(itemRepo ItemRepository, images ImageStore, minAge time.Duration) *ImageGC {
+	return &ImageGC{itemRepo: itemRepo, images: images, minAge: minAge, now: time.Now}
+}
+
+// ImageGCResult is the result of a garbage collection.
+type ImageGCResult struct {
+	// Scanned is the number of the images in the store.
+	Scanned int
+	// Deleted are the names of the deleted images, or the images to be deleted in a dry run.
+	Deleted []string
+	// DeletedBytes is the total size of Deleted.
+	DeletedBytes int64
+	// Recent is the number of the unreferenced images kept because they are younger than the minimum age.
+	Recent int
+}
+
+// Run deletes the unreferenced images. If dryRun is true, it only reports the images to be deleted.
+// It continues on failures to delete images, and returns them joined with the result.
+func (gc *ImageGC) Run(ctx context.Context, dryRun bool) (*ImageGCResult, error) {
+	// list the images before marking, so that an image stored after marking is never swept
+	stored, err := gc.images.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	cutoff := gc.now().Add(-gc.minAge)
+
+	names, err := gc.itemRepo.ImageNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	marked := map[string]bool{}
+	for _, name := range append(names, defaultImageName) {
+		// names stored by old versions include the directory, such as images/<name>
+		name = path.Base(name)
+		marked[name] = true
+		for _, width := range variantWidths {
+			marked[variantName(name, width)] = true
+		}
+	}
+
+	result := &ImageGCResult{Scanned: len(stored)}
+	var errs []error
+	for _, img := range stored {
+		// leave files which are not images, such as .gitkeep, and the quarantined images for inspection
+		if marked[img.Name] || !isImageExt(path.Ext(img.Name)) || strings.HasPrefix(img.Name, quarantineDir+"/") {
+			continue
+		}
+		if img.ModTime.After(cutoff) {
+			result.Recent++
+			continue
+		}
+		if !dryRun {
+			if ok, err := gc.sweep(ctx, img.Name, cutoff); err != nil {
+				errs = append(errs, err)
+				continue
+			} else if !ok {
+				result.Recent++
+				continue
+			}
+		}
+		result.Deleted = append(result.Deleted, img.Name)
+		result.DeletedBytes += img.Size
+	}
+	return result, errors.Join(errs...)
+}
+
+// sweep deletes the image unless it has been stored again after cutoff or referred to by an item
+// since it was listed.
+func (gc *ImageGC) sweep(ctx context.Context, name string, cutoff time.Time) (bool, error) {
+	unlock := lockImage(name)
+	defer unlock()
+
+	img, err := gc.images.Get(ctx, name)
+	if errors.Is(err, errImageNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check image %s: %w", name, err)
+	}
+	img.Close()
+	if img.ModTime.After(cutoff) {
+		return false, nil
+	}
+	if referenced, err := gc.itemRepo.IsImageReferenced(ctx, name); err != nil {
+		return false, err
+	} else if referenced {
+		return false, nil
+	}
+	if err := gc.images.Delete(ctx, name); err != nil {
+		return false, fmt.Errorf("failed to delete image %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// imageLocks serialize storing and sweeping the images within the process.
+// An image name always takes the same lock.
+var imageLocks [64]sync.Mutex
+
+// lockImage locks the image name and returns the function to unlock it.
+func lockImage(name string) (unlock func()) {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	mu := &imageLocks[h.Sum32()%uint32(len(imageLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// runImageGC runs gc every interval until ctx is done.
+func runImageGC(ctx context.Context, gc *ImageGC, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := gc.Run(ctx, false)
+		if err != nil {
+			slog.Error("failed to collect unreferenced images", "error", err)
+		}
+		if result != nil {
+			slog.Info("collected unreferenced images", "scanned", result.Scanned, "deleted", len(result.Deleted),
+				"deleted_bytes", result.DeletedBytes, "recent", result.Recent)
+		}
+	}
+}
+
+// RunGCImagesCommand runs the gc-images command, which deletes the images no item refers to.
+// It returns the exit code.
+//
+//	gc-images            deletes the unreferenced images older than the minimum age
+//	gc-images dry-run    lists the images to be deleted without deleting them
+func RunGCImagesCommand(cfg Config, args []string, stdout io.Writer) int {
+	dryRun := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "dry-run":
+		dryRun = true
+	default:
+		fmt.Fprintln(stdout, "usage: gc-images [dry-run]")
+		return 2
+	}
+
+	db, err := sql.Open("sqlite3", cfg.DBPath)
+	if err != nil {
+		slog.Error("failed to open DB", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	images, err := newImageStore(cfg)
+	if err != nil {
+		slog.Error("failed to set up image store", "error", err)
+		return 1
+	}
+
+	gc := NewImageGC(NewItemRepository(db), images, cfg.ImageGCMinAge)
+	result, err := gc.Run(context.Background(), dryRun)
+	if result != nil {
+		verb := "deleted"
+		if dryRun {
+			verb = "would delete"
+		}
+		for _, name := range result.Deleted {
+			fmt.Fprintf(stdout, "%s %s\n", verb, name)
+		}
+		fmt.Fprintf(stdout, "%s %d of %d images (%d bytes), kept %d unreferenced images younger than %s\n",
+			verb, len(result.Deleted), result.Scanned, result.DeletedBytes, result.Recent, cfg.ImageGCMinAge)
+	}
+	if err != nil {
+		slog.Error("failed to collect unreferenced images", "error", err)
+		return 1
+	}
+	return 0
+}
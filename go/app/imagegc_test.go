@@ -0,0 +1,256 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/mock/gomock"
+)
+
+// putImageAt stores an image in the memory store with the modification time.
+func putImageAt(s *MemoryImageStore, name string, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[name] = memoryImage{data: []byte("image " + name), modTime: modTime}
+}
+
+func TestImageGC(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	old, recent := now.Add(-2*time.Hour), now.Add(-10*time.Minute)
+
+	cases := map[string]struct {
+		stored     map[string]time.Time
+		referenced []string
+		dryRun     bool
+		// uploaded is stored again between listing and marking, as a concurrent upload does.
+		uploaded string
+		// saved are referred to by the items saved between marking and sweeping.
+		saved       []string
+		wantDeleted []string
+		wantRecent  int
+	}{
+		"ok: unreferenced images and their variants are deleted": {
+			stored: map[string]time.Time{
+				"a.jpg":               old,
+				"variants/a_w150.jpg": old,
+				"b.png":               old,
+				"variants/b_w400.png": old,
+			},
+			referenced:  []string{"a.jpg"},
+			wantDeleted: []string{"b.png", "variants/b_w400.png"},
+		},
+		"ok: names with the legacy directory are referenced": {
+			stored:     map[string]time.Time{"a.jpg": old, "variants/a_w1024.jpg": old},
+			referenced: []string{"images/a.jpg"},
+		},
+		"ok: default image is kept": {
+			stored: map[string]time.Time{"default.jpg": old, "variants/default_w150.jpg": old},
+		},
+		"ok: recent images are kept": {
+			stored:      map[string]time.Time{"a.jpg": old, "b.jpg": recent},
+			wantDeleted: []string{"a.jpg"},
+			wantRecent:  1,
+		},
+		"ok: files other than images are kept": {
+			stored: map[string]time.Time{".gitkeep": old, "notes.txt": old},
+		},
+		"ok: dry run reports the images to be deleted": {
+			stored:      map[string]time.Time{"a.jpg": old, "b.jpg": old},
+			referenced:  []string{"b.jpg"},
+			dryRun:      true,
+			wantDeleted: []string{"a.jpg"},
+		},
+		"ok: image uploaded again during the collection is kept": {
+			stored:      map[string]time.Time{"a.jpg": old, "b.jpg": old},
+			uploaded:    "a.jpg",
+			wantDeleted: []string{"b.jpg"},
+			wantRecent:  1,
+		},
+		"ok: image referred to during the collection is kept": {
+			stored:      map[string]time.Time{"a.jpg": old, "b.jpg": old},
+			saved:       []string{"a.jpg"},
+			wantDeleted: []string{"b.jpg"},
+			wantRecent:  1,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			store := NewMemoryImageStore()
+			for name, modTime := range tt.stored {
+				putImageAt(store, name, modTime)
+			}
+			itemRepo := NewMockItemRepository(ctrl)
+			itemRepo.EXPECT().ImageNames(gomock.Any()).DoAndReturn(func(ctx context.Context) ([]string, error) {
+				if tt.uploaded != "" {
+					putImageAt(store, tt.uploaded, now)
+				}
+				return tt.referenced, nil
+			})
+			itemRepo.EXPECT().IsImageReferenced(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, name string) (bool, error) {
+				return slices.Contains(tt.saved, name), nil
+			}).AnyTimes()
+
+			gc := NewImageGC(itemRepo, store, time.Hour)
+			gc.now = func() time.Time { return now }
+			got, err := gc.Run(t.Context(), tt.dryRun)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			slices.Sort(got.Deleted)
+			if diff := cmp.Diff(tt.wantDeleted, got.Deleted, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected deleted images (-want +got):\n%s", diff)
+			}
+			if got.Scanned != len(tt.stored) || got.Recent != tt.wantRecent {
+				t.Errorf("expected %d scanned and %d recent images, got %+v", len(tt.stored), tt.wantRecent, got)
+			}
+
+			for name := range tt.stored {
+				ok, err := store.Exists(t.Context(), name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if deleted := !tt.dryRun && slices.Contains(tt.wantDeleted, name); ok == deleted {
+					t.Errorf("expected %s to be deleted: %v, but exists: %v", name, deleted, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestImageGCError(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	itemRepo := NewMockItemRepository(ctrl)
+	itemRepo.EXPECT().ImageNames(gomock.Any()).Return(nil, errors.New("database is locked"))
+
+	store := NewMemoryImageStore()
+	putImageAt(store, "a.jpg", time.Now().Add(-2*time.Hour))
+
+	// nothing is deleted when the references are unknown
+	if _, err := NewImageGC(itemRepo, store, time.Hour).Run(t.Context(), false); err == nil {
+		t.Error("expected an error")
+	}
+	if ok, _ := store.Exists(t.Context(), "a.jpg"); !ok {
+		t.Error("expected a.jpg to be kept")
+	}
+}
+
+func TestRunGCImagesCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.DBPath = filepath.Join(dir, "test.sqlite3")
+	cfg.ImageDirPath = filepath.Join(dir, "images")
+
+	db, err := sql.Open("sqlite3", cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := setupDatabase(t.Context(), db); err != nil {
+		t.Fatal(err)
+	}
+	itemRepo := NewItemRepository(db)
+	item := &Item{Name: "jacket", Images: []*ItemImage{{ImageName: "a.jpg"}}}
+	if err := itemRepo.Insert(t.Context(), item); err != nil {
+		t.Fatal(err)
+	}
+	legacy := &Item{Name: "coat", Images: []*ItemImage{{ImageName: "images/d.jpg"}}}
+	if err := itemRepo.Insert(t.Context(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"a.jpg": true, "d.jpg": true, "b.jpg": false, "_.jpg": false} {
+		if got, err := itemRepo.IsImageReferenced(t.Context(), name); err != nil || got != want {
+			t.Errorf("expected %s to be referenced: %v, got %v, %v", name, want, got, err)
+		}
+	}
+
+	old := time.Now().Add(-2 * cfg.ImageGCMinAge)
+	for _, name := range []string{"a.jpg", "b.jpg", "variants/b_w150.jpg", "default.jpg", "c.jpg"} {
+		p := filepath.Join(cfg.ImageDirPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// c.jpg is uploaded just now
+		if name != "c.jpg" {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases := []struct {
+		args []string
+		code int
+		want []string
+		// remaining are the images left after the command.
+		remaining []string
+	}{
+		{
+			args:      []string{"sideways"},
+			code:      2,
+			want:      []string{"usage"},
+			remaining: []string{"a.jpg", "b.jpg", "c.jpg", "default.jpg", "variants/b_w150.jpg"},
+		},
+		{
+			args:      []string{"dry-run"},
+			code:      0,
+			want:      []string{"would delete b.jpg", "would delete variants/b_w150.jpg", "would delete 2 of 5 images", "kept 1 unreferenced"},
+			remaining: []string{"a.jpg", "b.jpg", "c.jpg", "default.jpg", "variants/b_w150.jpg"},
+		},
+		{
+			code:      0,
+			want:      []string{"deleted b.jpg", "deleted variants/b_w150.jpg", "deleted 2 of 5 images"},
+			remaining: []string{"a.jpg", "c.jpg", "default.jpg"},
+		},
+	}
+
+	for _, tt := range cases {
+		var out bytes.Buffer
+		if code := RunGCImagesCommand(cfg, tt.args, &out); code != tt.code {
+			t.Errorf("gc-images %v: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("gc-images %v: expected output to contain %q, got %q", tt.args, want, out.String())
+			}
+		}
+
+		list, err := NewLocalImageStore(cfg.ImageDirPath).List(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var remaining []string
+		for _, img := range list {
+			remaining = append(remaining, img.Name)
+		}
+		slices.Sort(remaining)
+		if diff := cmp.Diff(tt.remaining, remaining); diff != "" {
+			t.Errorf("gc-images %v: unexpected remaining images (-want +got):\n%s", tt.args, diff)
+		}
+	}
+}
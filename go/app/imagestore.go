@@ -0,0 +1,725 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageURLPath returns the path of GetImage serving the image.
+func imageURLPath(name string) string {
+	return "/images/" + url.PathEscape(name)
+}
+
+// validateImageName checks that name stays inside the store.
+func validateImageName(name string) error {
+	if name == "" || !filepath.IsLocal(name) || strings.Contains(name, `\`) {
+		return fmt.Errorf("%w: %q", errInvalidImageName, name)
+	}
+	return nil
+}
+
+// nopSeekCloser is an in-memory image which needs no closing.
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// fileImageStore is implemented by image stores which can take over files, such as LocalImageStore.
+// Uploads are moved into them instead of being copied.
+type fileImageStore interface {
+	// TempDir returns the directory to create the files to be passed to PutFile.
+	TempDir() (string, error)
+	// PutFile moves the file at src into the store as name, replacing an existing image atomically.
+	PutFile(ctx context.Context, name, src string) error
+}
+
+// LocalImageStore stores images as files under a directory.
+type LocalImageStore struct {
+	dir string
+}
+
+func NewLocalImageStore(dir string) *LocalImageStore {
+	return &LocalImageStore{dir: dir}
+}
+
+func (s *LocalImageStore) path(name string) (string, error) {
+	if err := validateImageName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
+
+// Put writes the image to a temporary file and renames it, so that readers never see a partial file.
+// The file and the directory are synced, so that a crash never leaves a partial file either.
+func (s *LocalImageStore) Put(ctx context.Context, name string, r io.Reader) (e error) {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create image directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		if e != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to store image file: %w", err)
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+// syncFile flushes the file or the directory at name to the disk.
+// Syncing a directory persists the files renamed into it.
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func syncDir(dir string) error {
+	if err := syncFile(dir); err != nil {
+		return fmt.Errorf("failed to sync image directory: %w", err)
+	}
+	return nil
+}
+
+// TempDir returns a hidden directory in the store, so that the files in it can be renamed into the store
+// on the same file system.
+func (s *LocalImageStore) TempDir() (string, error) {
+	dir := filepath.Join(s.dir, ".uploads")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return dir, nil
+}
+
+// PutFile renames src to the image file. It falls back to copying src if it cannot be renamed,
+// such as when it is on another file system.
+func (s *LocalImageStore) PutFile(ctx context.Context, name, src string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create image directory: %w", err)
+	}
+	// temporary files are only readable by the owner
+	if err := os.Chmod(src, 0644); err != nil {
+		return fmt.Errorf("failed to store image file: %w", err)
+	}
+	if err := syncFile(src); err != nil {
+		return fmt.Errorf("failed to sync image file: %w", err)
+	}
+	if err := os.Rename(src, p); err == nil {
+		return syncDir(filepath.Dir(p))
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open image file: %w", err)
+	}
+	defer f.Close()
+	if err := s.Put(ctx, name, f); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (s *LocalImageStore) Get(ctx context.Context, name string) (*StoredImage, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errImageNotFound
+		}
+		return nil, fmt.Errorf("failed to open image file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat image file: %w", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, errImageNotFound
+	}
+	return &StoredImage{ReadSeekCloser: f, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalImageStore) Exists(ctx context.Context, name string) (bool, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error checking image existence: %w", err)
+	}
+	return !info.IsDir(), nil
+}
+
+func (s *LocalImageStore) Delete(ctx context.Context, name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete image file: %w", err)
+	}
+	return nil
+}
+
+// List walks the directory skipping hidden files, such as the temporary files of Put.
+func (s *LocalImageStore) List(ctx context.Context) ([]ImageInfo, error) {
+	var images []ImageInfo
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == s.dir {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		images = append(images, ImageInfo{Name: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list image files: %w", err)
+	}
+	return images, nil
+}
+
+func (s *LocalImageStore) URL(name string) string {
+	return imageURLPath(name)
+}
+
+// MemoryImageStore keeps images in memory. It is meant for tests.
+type MemoryImageStore struct {
+	mu     sync.RWMutex
+	images map[string]memoryImage
+}
+
+type memoryImage struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryImageStore() *MemoryImageStore {
+	return &MemoryImageStore{images: map[string]memoryImage{}}
+}
+
+func (s *MemoryImageStore) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := validateImageName(name); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[name] = memoryImage{data: data, modTime: time.Now()}
+	return nil
+}
+
+func (s *MemoryImageStore) Get(ctx context.Context, name string) (*StoredImage, error) {
+	if err := validateImageName(name); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	img, ok := s.images[name]
+	if !ok {
+		return nil, errImageNotFound
+	}
+	return &StoredImage{
+		ReadSeekCloser: nopSeekCloser{bytes.NewReader(img.data)},
+		Size:           int64(len(img.data)),
+		ModTime:        img.modTime,
+	}, nil
+}
+
+func (s *MemoryImageStore) Exists(ctx context.Context, name string) (bool, error) {
+	if err := validateImageName(name); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.images[name]
+	return ok, nil
+}
+
+func (s *MemoryImageStore) Delete(ctx context.Context, name string) error {
+	if err := validateImageName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.images, name)
+	return nil
+}
+
+func (s *MemoryImageStore) List(ctx context.Context) ([]ImageInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	images := make([]ImageInfo, 0, len(s.images))
+	for name, img := range s.images {
+		images = append(images, ImageInfo{Name: name, Size: int64(len(img.data)), ModTime: img.modTime})
+	}
+	return images, nil
+}
+
+func (s *MemoryImageStore) URL(name string) string {
+	return imageURLPath(name)
+}
+
+// S3Config is the configuration of an S3-compatible storage such as Amazon S3 and MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the storage, such as https://s3.ap-northeast-1.amazonaws.com .
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the object keys, such as images/ .
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PublicURL is the base URL where clients fetch the objects directly.
+	// If it is empty, images are served through this server.
+	PublicURL string
+}
+
+// S3ImageStore stores images as objects of a bucket in an S3-compatible storage.
+// It uses path-style requests signed with AWS Signature Version 4.
+type S3ImageStore struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// now returns the current time used for signing requests.
+	now func() time.Time
+}
+
+// s3Timeout bounds a request to S3 including reading the response body, so that a stalled
+// storage does not hang the handlers and the commands. It is longer than the default write timeout.
+const s3Timeout = time.Minute
+
+func NewS3ImageStore(cfg S3Config, client *http.Client) (*S3ImageStore, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: s3Timeout}
+	}
+	return &S3ImageStore{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+// do sends a signed request for the object stored as name.
+func (s *S3ImageStore) do(ctx context.Context, method, name string, body []byte) (*http.Response, error) {
+	if err := validateImageName(name); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if method == http.MethodPut {
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		// clients fetching the objects from PublicURL cache them as the images served by GetImage
+		if isImmutableImageName(name) {
+			header.Set("Cache-Control", immutableCacheControl)
+		}
+	}
+	return s.send(ctx, method, s.cfg.Prefix+name, nil, header, body)
+}
+
+// send sends a signed request for the object key in the bucket. An empty key addresses the bucket.
+func (s *S3ImageStore) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = path.Join("/", s.endpoint.Path, s.cfg.Bucket, key)
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3EscapeQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 request: %w", err)
+	}
+	if body == nil {
+		req.Body, req.ContentLength = http.NoBody, 0
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := sha256.Sum256(body)
+	signV4(req, hex.EncodeToString(payloadHash[:]), s.cfg, s.now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send S3 request: %w", err)
+	}
+	return res, nil
+}
+
+// s3Error reads the error response of S3.
+func s3Error(res *http.Response, op, name string) error {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("failed to %s %s: S3 responded %s: %s", op, name, res.Status, bytes.TrimSpace(b))
+}
+
+func (s *S3ImageStore) Put(ctx context.Context, name string, r io.Reader) error {
+	// S3 needs the length and the hash of the payload before sending it
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	res, err := s.do(ctx, http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res, "put", name)
+	}
+	return nil
+}
+
+func (s *S3ImageStore) Get(ctx context.Context, name string) (*StoredImage, error) {
+	res, err := s.get(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return &StoredImage{
+		ReadSeekCloser: &s3Object{ctx: ctx, store: s, name: name, size: res.ContentLength, body: res.Body},
+		Size:           res.ContentLength,
+		ModTime:        modTime,
+	}, nil
+}
+
+// get requests the object stored as name from offset. The caller must close the response body.
+func (s *S3ImageStore) get(ctx context.Context, name string, offset int64) (*http.Response, error) {
+	if err := validateImageName(name); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := s.send(ctx, http.MethodGet, s.cfg.Prefix+name, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusOK && offset == 0, res.StatusCode == http.StatusPartialContent:
+		return res, nil
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, errImageNotFound
+	default:
+		defer res.Body.Close()
+		return nil, s3Error(res, "get", name)
+	}
+}
+
+// s3Object streams an object from S3 without reading it into memory. Seeking only moves the offset,
+// and the next read requests the rest of the object from there, so http.ServeContent can find
+// the size and serve ranges without downloading the object again.
+type s3Object struct {
+	ctx   context.Context
+	store *S3ImageStore
+	name  string
+	size  int64
+	// body is the response body positioned at bodyOffset, and offset is the position to read next.
+	body       io.ReadCloser
+	bodyOffset int64
+	offset     int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil || o.bodyOffset != o.offset {
+		if o.body != nil {
+			o.body.Close()
+		}
+		o.body = nil
+		res, err := o.store.get(o.ctx, o.name, o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyOffset = res.Body, o.offset
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+func (s *S3ImageStore) Exists(ctx context.Context, name string) (bool, error) {
+	res, err := s.do(ctx, http.MethodHead, name, nil)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, s3Error(res, "check", name)
+	}
+}
+
+func (s *S3ImageStore) Delete(ctx context.Context, name string) error {
+	res, err := s.do(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s3Error(res, "delete", name)
+	}
+}
+
+// s3ListResult is the response of ListObjectsV2.
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+// List lists the objects under the prefix with ListObjectsV2, following the continuation tokens.
+func (s *S3ImageStore) List(ctx context.Context) ([]ImageInfo, error) {
+	var images []ImageInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+	for {
+		res, err := s.send(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			err := s3Error(res, "list", s.cfg.Bucket)
+			res.Body.Close()
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode S3 object list: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, s.cfg.Prefix)
+			if validateImageName(name) != nil {
+				continue
+			}
+			images = append(images, ImageInfo{Name: name, Size: obj.Size, ModTime: obj.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return images, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3ImageStore) URL(name string) string {
+	if s.cfg.PublicURL == "" {
+		return imageURLPath(name)
+	}
+	return strings.TrimSuffix(s.cfg.PublicURL, "/") + s3EscapePath("/"+s.cfg.Prefix+name)
+}
+
+// s3EscapePath percent-encodes p as S3 expects, leaving only the unreserved characters and slashes.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for _, c := range []byte(p) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3EscapeQuery encodes query sorted by keys with the same escaping as paths, which SigV4 requires
+// for the canonical query string.
+func s3EscapeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, v := range query[key] {
+			parts = append(parts, s3EscapeQueryComponent(key)+"="+s3EscapeQueryComponent(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3EscapeQueryComponent escapes s like s3EscapePath, but escapes slashes as well.
+func s3EscapeQueryComponent(s string) string {
+	return strings.ReplaceAll(s3EscapePath(s), "/", "%2F")
+}
+
+// signV4 signs req with AWS Signature Version 4. All the headers set to req are signed along with Host.
+// The query of req must be encoded by s3EscapeQuery, which is the canonical form.
+func signV4(req *http.Request, payloadHash string, cfg S3Config, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + cfg.SecretAccessKey)
+	for _, part := range []string{date, cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -3,17 +3,41 @@ package app
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"html"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var (
-	errImageNotFound    = errors.New("image not found")
-	errItemNotFound     = errors.New("item not found")
-	errCategoryNotFound = errors.New("category not found")
+	errImageNotFound     = errors.New("image not found")
+	errInvalidImageName  = errors.New("invalid image name")
+	errItemNotFound      = errors.New("item not found")
+	errCategoryNotFound  = errors.New("category not found")
+	errInvalidCursor     = errors.New("invalid cursor")
+	errEmptySearchQuery  = errors.New("search query has no terms")
+	errCategoryExists    = errors.New("category already exists")
+	errCategoryInUse     = errors.New("category has items or subcategories")
+	errParentNotFound    = errors.New("parent category not found")
+	errCategoryCycle     = errors.New("category cannot be moved under itself or its subcategories")
+	errTooManyImages     = fmt.Errorf("an item can have at most %d images", maxItemImages)
+	errItemImageNotFound = errors.New("item image not found")
+	errInvalidImageOrder = errors.New("image order must list every image of the item exactly once")
+	errUserNotFound      = errors.New("user not found")
+	errUserExists        = errors.New("user with the email already exists")
+	errSessionNotFound   = errors.New("session not found")
+	// errRefreshTokenReused is returned when the refresh token of a rotated session is used again,
+	// which means that the token was stolen by someone.
+	errRefreshTokenReused = errors.New("refresh token already used")
+	errUnknownRole        = errors.New("unknown role")
 )
 
 type Item struct {
@@ -21,60 +45,327 @@ type Item struct {
 	Name       string `db:"name" json:"name"`
 	Category   string `db:"category" json:"category"`
 	CategoryID int    `db:"category_id" json:"-"`
-	ImageName  string `db:"image_name" json:"image_name"`
+	// SellerID is the id of the user who listed the item. It is nil for the items added before accounts.
+	SellerID *int `db:"seller_id" json:"-"`
+	// Price is the price in yen, and Condition is the condition of the item.
+	// They are nil for the items listed before prices.
+	Price       *int       `db:"price" json:"-"`
+	Condition   *Condition `db:"condition" json:"-"`
+	Description string     `db:"description" json:"-"`
+	// Brand is the brand of the item, or empty if it has none.
+	Brand string `db:"brand" json:"-"`
+	// HiddenAt is the time a moderator hid the item. It is nil for a visible item.
+	HiddenAt *time.Time `db:"hidden_at" json:"-"`
+	// ImageName is the name of the cover image.
+	ImageName string    `db:"image_name" json:"image_name"`
+	CreatedAt time.Time `db:"created_at" json:"-"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+	// Images are the images of the item ordered by position.
+	Images []*ItemImage `json:"-"`
+}
+
+// Condition is the condition of an item, from new to poor.
+type Condition string
+
+const (
+	ConditionNew     Condition = "new"
+	ConditionLikeNew Condition = "like_new"
+	ConditionGood    Condition = "good"
+	ConditionFair    Condition = "fair"
+	ConditionPoor    Condition = "poor"
+)
+
+// itemConditions are all the conditions of items from the best.
+var itemConditions = []Condition{ConditionNew, ConditionLikeNew, ConditionGood, ConditionFair, ConditionPoor}
+
+// maxItemImages is the maximum number of images of an item.
+const maxItemImages = 10
+
+// ItemImage is one of the images of an item.
+type ItemImage struct {
+	ID        int    `db:"id" json:"id"`
+	ItemID    int    `db:"item_id" json:"-"`
+	ImageName string `db:"image_name" json:"image_name"`
+	// Position is the 0-based display order of the image.
+	Position int `db:"position" json:"position"`
+	// IsCover is true for the image shown in item lists. An item with images has exactly one cover.
+	IsCover bool `db:"is_cover" json:"is_cover"`
+}
+
+// ItemSort is the order in which items are listed.
+type ItemSort string
+
+const (
+	// ItemSortNewest lists the most recently added items first.
+	ItemSortNewest ItemSort = "newest"
+	// ItemSortName lists items by name in ascending order.
+	ItemSortName ItemSort = "name"
+	// ItemSortPrice lists items by price in ascending order. The items without a price come last.
+	ItemSortPrice ItemSort = "price"
+)
+
+// ListItemsParams holds the conditions to list a page of items.
+type ListItemsParams struct {
+	// Limit is the maximum number of items in the page.
+	Limit int
+	// Cursor is the opaque cursor returned with the previous page. Empty means the first page.
+	Cursor string
+	Sort   ItemSort
+	// CategoryID limits the items to the category and its subcategories if not zero.
+	CategoryID int
+	// SellerID limits the items to the ones listed by the user if not zero.
+	SellerID int
+	// IncludeHidden lists the hidden items as well, such as for their seller.
+	IncludeHidden bool
+}
+
+// ItemPage is a page of items.
+type ItemPage struct {
+	Items []*Item
+	// NextCursor is the cursor to fetch the next page. Empty if there are no more items.
+	NextCursor string
+}
+
+// SearchResult is an item matching a search query.
+type SearchResult struct {
+	Item
+	// Snippet is an HTML-escaped excerpt of the matched text with the matches wrapped in <mark> tags.
+	Snippet string `json:"snippet"`
+	// Score is the relevance of the item. A higher score is more relevant.
+	Score float64 `json:"score"`
 }
 
 type Category struct {
 	ID   int    `db:"id" json:"id"`
 	Name string `db:"name" json:"name"`
+	// ParentID is the id of the parent category. It is nil for a root category.
+	ParentID *int `db:"parent_id" json:"parent_id"`
+	// ItemCount is the number of visible items in the category, as listed by ListPage.
+	ItemCount int `db:"item_count" json:"item_count"`
+}
+
+// Role is the role of a user, which decides the permissions of the user besides changing the own items.
+// The permissions of the roles are stored in the role_permissions table.
+type Role string
+
+const (
+	RoleUser Role = "user"
+	// RoleModerator can hide items and merge categories.
+	RoleModerator Role = "moderator"
+	// RoleAdmin has all the permissions.
+	RoleAdmin Role = "admin"
+)
+
+// Permission is an operation granted to roles, such as "items:hide".
+type Permission string
+
+const (
+	// PermChangeAnyItem allows changing and deleting the items of other users.
+	PermChangeAnyItem Permission = "items:change_any"
+	PermHideItems     Permission = "items:hide"
+	// PermManageCategories allows adding, changing and deleting categories.
+	PermManageCategories Permission = "categories:manage"
+	PermMergeCategories  Permission = "categories:merge"
+	// PermManageUsers allows listing users and changing their roles.
+	PermManageUsers Permission = "users:manage"
+)
+
+// AuditEvent is a record of an authorization decision, such as a denied request.
+type AuditEvent struct {
+	ID int `db:"id"`
+	// UserID is the id of the authenticated user. It is nil for an anonymous request.
+	UserID *int `db:"user_id"`
+	// Action is the method and the path of the request, such as "DELETE /items/1".
+	Action     string     `db:"action"`
+	Permission Permission `db:"permission"`
+	Outcome    string     `db:"outcome"`
+	RequestID  string     `db:"request_id"`
+	RemoteAddr string     `db:"remote_addr"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// User is a registered user.
+type User struct {
+	ID int `db:"id" json:"id"`
+	// Email identifies the user on login. It is stored in lower case.
+	Email string `db:"email" json:"email"`
+	Name  string `db:"name" json:"name"`
+	Role  Role   `db:"role" json:"role"`
+	// PasswordHash is the encoded hash of the password made by hashPassword.
+	PasswordHash string    `db:"password_hash" json:"-"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// Session is a login session of a user. Only the hashes of its tokens are stored.
+type Session struct {
+	ID     int `db:"id"`
+	UserID int `db:"user_id"`
+	// FamilyID is shared by the sessions refreshed one after another from the same login.
+	FamilyID         string    `db:"family_id"`
+	AccessTokenHash  string    `db:"access_token_hash"`
+	RefreshTokenHash string    `db:"refresh_token_hash"`
+	AccessExpiresAt  time.Time `db:"access_expires_at"`
+	RefreshExpiresAt time.Time `db:"refresh_expires_at"`
+	CreatedAt        time.Time `db:"created_at"`
 }
 
 //go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
 type ItemRepository interface {
 	Insert(ctx context.Context, item *Item) error
 	List(ctx context.Context) ([]*Item, error)
+	ListPage(ctx context.Context, params ListItemsParams) (*ItemPage, error)
 	Select(ctx context.Context, id int) (*Item, error)
-	SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error)
+	Update(ctx context.Context, item *Item, imageNames []string) error
+	Delete(ctx context.Context, id int) error
+	SearchByKeyword(ctx context.Context, keyword string) ([]*SearchResult, error)
+	AddImages(ctx context.Context, itemID int, imageNames []string) error
+	ReplaceImages(ctx context.Context, itemID int, imageNames []string) error
+	DeleteImage(ctx context.Context, itemID, imageID int) error
+	ReorderImages(ctx context.Context, itemID int, imageIDs []int, coverID int) error
+	ImageNames(ctx context.Context) ([]string, error)
+	IsImageReferenced(ctx context.Context, name string) (bool, error)
+	SetHidden(ctx context.Context, id int, hidden bool) error
 }
 
 type CategoryRepository interface {
 	GetOrCreate(ctx context.Context, name string) (int, error)
 	GetByID(ctx context.Context, id int) (*Category, error)
+	GetByName(ctx context.Context, name string) (*Category, error)
+	List(ctx context.Context) ([]*Category, error)
+	Create(ctx context.Context, category *Category) error
+	Update(ctx context.Context, category *Category) error
+	Delete(ctx context.Context, id int) error
+	Merge(ctx context.Context, sourceID, targetID int) error
+}
+
+type UserRepository interface {
+	Create(ctx context.Context, user *User) error
+	GetByID(ctx context.Context, id int) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	List(ctx context.Context) ([]*User, error)
+	SetRole(ctx context.Context, id int, role Role) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *Session) error
+	GetByAccessToken(ctx context.Context, accessTokenHash string) (*Session, error)
+	Rotate(ctx context.Context, refreshTokenHash string, next *Session, now time.Time) error
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
+type RoleRepository interface {
+	HasPermission(ctx context.Context, role Role, permission Permission) (bool, error)
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *AuditEvent) error
 }
 
 type itemRepository struct {
 	db *sql.DB
+	// hasSearchIndex reports whether the FTS5 index items_fts is available.
+	hasSearchIndex bool
 }
 
 type categoryRepository struct {
 	db *sql.DB
 }
 
+type userRepository struct {
+	db *sql.DB
+}
+
+type sessionRepository struct {
+	db *sql.DB
+}
+
+type roleRepository struct {
+	db *sql.DB
+}
+
+type auditRepository struct {
+	db *sql.DB
+}
+
 func NewItemRepository(db *sql.DB) ItemRepository {
-	return &itemRepository{db: db}
+	return &itemRepository{db: db, hasSearchIndex: hasSearchIndex(db)}
 }
 
 func NewCategoryRepository(db *sql.DB) CategoryRepository {
 	return &categoryRepository{db: db}
 }
 
-// Insert inserts an item into the repository.
-func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
-	const query = `INSERT INTO items (name, category_id, image_name) VALUES (?, ?, ?)`
-	result, err := i.db.ExecContext(ctx, query, item.Name, item.CategoryID, item.ImageName)
+func NewUserRepository(db *sql.DB) UserRepository {
+	return &userRepository{db: db}
+}
+
+func NewSessionRepository(db *sql.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func NewRoleRepository(db *sql.DB) RoleRepository {
+	return &roleRepository{db: db}
+}
+
+func NewAuditRepository(db *sql.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+// itemColumns are the columns of an item scanned by itemScanDest.
+// The query must alias items as i and join categories as c.
+const itemColumns = `i.id, i.name, i.category_id, c.name AS category_name, i.seller_id, i.price, i.condition, i.description, i.brand,
+    i.hidden_at, i.image_name, i.created_at, i.updated_at`
+
+// itemScanDest returns the destinations to scan itemColumns into.
+func itemScanDest(it *Item) []any {
+	return []any{&it.ID, &it.Name, &it.CategoryID, &it.Category, &it.SellerID, &it.Price, &it.Condition, &it.Description, &it.Brand,
+		&it.HiddenAt, &it.ImageName, &it.CreatedAt, &it.UpdatedAt}
+}
+
+// Insert inserts an item with its images into the repository.
+// An item with only ImageName gets it as the single image.
+// It sets the ids and the timestamps of the inserted item and the positions of the images.
+func (i *itemRepository) Insert(ctx context.Context, item *Item) (e error) {
+	if len(item.Images) == 0 && item.ImageName != "" {
+		item.Images = []*ItemImage{{ImageName: item.ImageName}}
+	}
+	if len(item.Images) > maxItemImages {
+		return errTooManyImages
+	}
+	item.ImageName = arrangeImages(item.Images)
+
+	tx, err := i.db.BeginTx(ctx, nil)
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	const query = `
+        INSERT INTO items (name, category_id, seller_id, price, condition, description, brand, image_name)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING id, created_at, updated_at
+    `
+	row := tx.QueryRowContext(ctx, query, item.Name, item.CategoryID, item.SellerID, item.Price, item.Condition,
+		item.Description, item.Brand, item.ImageName)
+	if err := row.Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt); err != nil {
 		return fmt.Errorf("failed to insert item: %w", err)
 	}
-	id, err := result.LastInsertId()
-	if err == nil {
-		item.ID = int(id)
+	if err := syncImages(ctx, tx, item.ID, nil, item.Images); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	return nil
 }
 
 func (i *itemRepository) List(ctx context.Context) ([]*Item, error) {
 	const query = `
-        SELECT i.id, i.name, i.category_id, c.name as category_name, i.image_name 
+        SELECT ` + itemColumns + `
         FROM items i
         JOIN categories c ON i.category_id = c.id
     `
@@ -87,23 +378,171 @@ func (i *itemRepository) List(ctx context.Context) ([]*Item, error) {
 	var items []*Item
 	for rows.Next() {
 		var it Item
-		var categoryName string
-		if err := rows.Scan(&it.ID, &it.Name, &it.CategoryID, &categoryName, &it.ImageName); err != nil {
+		if err := rows.Scan(itemScanDest(&it)...); err != nil {
 			return nil, fmt.Errorf("failed to scan item: %w", err)
 		}
-		it.Category = categoryName
 		items = append(items, &it)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row error: %w", err)
 	}
+	if err := loadImages(ctx, i.db, items); err != nil {
+		return nil, err
+	}
 	return items, nil
 }
 
+// itemCursor is the position of the last item in a page.
+// It holds the key used by the sort order so that the next page can be fetched with a keyset query.
+type itemCursor struct {
+	Sort ItemSort `json:"s"`
+	Name string   `json:"n,omitempty"`
+	// Price is nil for ItemSortPrice when the last item has no price.
+	Price *int `json:"p,omitempty"`
+	ID    int  `json:"i"`
+}
+
+func encodeItemCursor(c itemCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeItemCursor(s string, sort ItemSort) (*itemCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c itemCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+	// a cursor is only valid for the sort order it was issued for
+	if c.Sort != sort {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// ListPage retrieves a page of items with a keyset query in the given sort order.
+func (i *itemRepository) ListPage(ctx context.Context, params ListItemsParams) (*ItemPage, error) {
+	var (
+		where   string
+		orderBy string
+		args    []any
+	)
+	var cursor *itemCursor
+	if params.Cursor != "" {
+		c, err := decodeItemCursor(params.Cursor, params.Sort)
+		if err != nil {
+			return nil, err
+		}
+		cursor = c
+	}
+
+	var (
+		with  string
+		conds []string
+	)
+	if params.CategoryID != 0 {
+		with = `
+        WITH RECURSIVE subtree (id) AS (
+            SELECT id FROM categories WHERE id = ?
+            UNION
+            SELECT child.id FROM categories child JOIN subtree ON child.parent_id = subtree.id
+        )`
+		conds = append(conds, "i.category_id IN (SELECT id FROM subtree)")
+		args = append(args, params.CategoryID)
+	}
+	if params.SellerID != 0 {
+		conds = append(conds, "i.seller_id = ?")
+		args = append(args, params.SellerID)
+	}
+	if !params.IncludeHidden {
+		conds = append(conds, "i.hidden_at IS NULL")
+	}
+
+	switch params.Sort {
+	case ItemSortNewest:
+		orderBy = "i.id DESC"
+		if cursor != nil {
+			conds = append(conds, "i.id < ?")
+			args = append(args, cursor.ID)
+		}
+	case ItemSortName:
+		orderBy = "i.name ASC, i.id ASC"
+		if cursor != nil {
+			conds = append(conds, "(i.name, i.id) > (?, ?)")
+			args = append(args, cursor.Name, cursor.ID)
+		}
+	case ItemSortPrice:
+		// NULL prices sort after all the prices, and then by id like the others
+		orderBy = "i.price IS NULL, i.price ASC, i.id ASC"
+		if cursor != nil && cursor.Price != nil {
+			conds = append(conds, "(i.price IS NULL OR (i.price, i.id) > (?, ?))")
+			args = append(args, *cursor.Price, cursor.ID)
+		} else if cursor != nil {
+			conds = append(conds, "i.price IS NULL AND i.id > ?")
+			args = append(args, cursor.ID)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sort order: %s", params.Sort)
+	}
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	query := with + `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        ` + where + `
+        ORDER BY ` + orderBy + `
+        LIMIT ?
+    `
+	// fetch one extra row to know whether there is a next page
+	args = append(args, params.Limit+1)
+
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*Item
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(itemScanDest(&it)...); err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+		items = append(items, &it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error: %w", err)
+	}
+
+	page := &ItemPage{Items: items}
+	if len(items) > params.Limit {
+		page.Items = items[:params.Limit]
+		last := page.Items[len(page.Items)-1]
+		c := itemCursor{Sort: params.Sort, ID: last.ID}
+		switch params.Sort {
+		case ItemSortName:
+			c.Name = last.Name
+		case ItemSortPrice:
+			c.Price = last.Price
+		}
+		page.NextCursor = encodeItemCursor(c)
+	}
+	if err := loadImages(ctx, i.db, page.Items); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
 // Select retrieves an item by id.
 func (i *itemRepository) Select(ctx context.Context, id int) (*Item, error) {
 	const query = `
-        SELECT i.id, i.name, i.category_id, c.name as category_name, i.image_name 
+        SELECT ` + itemColumns + `
         FROM items i
         JOIN categories c ON i.category_id = c.id
         WHERE i.id = ?
@@ -111,47 +550,564 @@ func (i *itemRepository) Select(ctx context.Context, id int) (*Item, error) {
 	row := i.db.QueryRowContext(ctx, query, id)
 
 	var it Item
-	var categoryName string
-	if err := row.Scan(&it.ID, &it.Name, &it.CategoryID, &categoryName, &it.ImageName); err != nil {
+	if err := row.Scan(itemScanDest(&it)...); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errItemNotFound
 		}
 		return nil, fmt.Errorf("failed to scan selected item: %w", err)
 	}
-	it.Category = categoryName
+	if err := loadImages(ctx, i.db, []*Item{&it}); err != nil {
+		return nil, err
+	}
 	return &it, nil
 }
 
-// SearchByKeyword searches items containing the keyword.
-func (i *itemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error) {
+// Update updates the name, category, listing details and cover image name of an item.
+// If imageNames is not nil, they replace all the images as ReplaceImages in the same transaction,
+// so that the item is not left half updated. Otherwise the images are kept.
+// It sets the update time of the item.
+func (i *itemRepository) Update(ctx context.Context, item *Item, imageNames []string) (e error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	const query = `
+        UPDATE items
+        SET name = ?, category_id = ?, price = ?, condition = ?, description = ?, brand = ?, image_name = ?,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+        RETURNING updated_at
+    `
+	row := tx.QueryRowContext(ctx, query, item.Name, item.CategoryID, item.Price, item.Condition, item.Description,
+		item.Brand, item.ImageName, item.ID)
+	if err := row.Scan(&item.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errItemNotFound
+		}
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	if imageNames != nil {
+		if err := modifyImagesTx(ctx, tx, item.ID, replaceImagesWith(imageNames)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes an item and its images by id.
+func (i *itemRepository) Delete(ctx context.Context, id int) (e error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM item_images WHERE item_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete item images: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM items WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return errItemNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SetHidden hides the item from lists and searches, or shows it again.
+// Hiding a hidden item keeps the time it was hidden first.
+func (i *itemRepository) SetHidden(ctx context.Context, id int, hidden bool) error {
+	query := `UPDATE items SET hidden_at = NULL WHERE id = ?`
+	args := []any{id}
+	if hidden {
+		query = `UPDATE items SET hidden_at = COALESCE(hidden_at, ?) WHERE id = ?`
+		args = []any{time.Now().UTC(), id}
+	}
+	result, err := i.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to change item visibility: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return errItemNotFound
+	}
+	return nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// loadImages sets the images of the items ordered by position.
+func loadImages(ctx context.Context, q queryer, items []*Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Item, len(items))
+	placeholders := make([]string, 0, len(items))
+	args := make([]any, 0, len(items))
+	for _, it := range items {
+		it.Images = []*ItemImage{}
+		byID[it.ID] = it
+		placeholders = append(placeholders, "?")
+		args = append(args, it.ID)
+	}
+
+	query := `
+        SELECT id, item_id, image_name, position, is_cover FROM item_images
+        WHERE item_id IN (` + strings.Join(placeholders, ", ") + `)
+        ORDER BY item_id, position, id
+    `
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query item images: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var img ItemImage
+		if err := rows.Scan(&img.ID, &img.ItemID, &img.ImageName, &img.Position, &img.IsCover); err != nil {
+			return fmt.Errorf("failed to scan item image: %w", err)
+		}
+		byID[img.ItemID].Images = append(byID[img.ItemID].Images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row error: %w", err)
+	}
+	return nil
+}
+
+// arrangeImages numbers the positions of the images in order and makes sure that
+// exactly one of them is the cover, which is the first one if none is chosen.
+// It returns the name of the cover image, or "" if there are no images.
+func arrangeImages(images []*ItemImage) string {
+	cover := -1
+	for i, img := range images {
+		img.Position = i
+		if img.IsCover && cover < 0 {
+			cover = i
+		}
+		img.IsCover = false
+	}
+	if len(images) == 0 {
+		return ""
+	}
+	cover = max(cover, 0)
+	images[cover].IsCover = true
+	return images[cover].ImageName
+}
+
+// syncImages makes the stored images of an item match images: the images missing in it are deleted,
+// the ones without an id are inserted, and the positions and covers of the others are updated.
+// images must be arranged by arrangeImages.
+func syncImages(ctx context.Context, tx *sql.Tx, itemID int, current, images []*ItemImage) error {
+	keep := map[int]bool{}
+	for _, img := range images {
+		keep[img.ID] = true
+	}
+	for _, img := range current {
+		if keep[img.ID] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM item_images WHERE id = ?`, img.ID); err != nil {
+			return fmt.Errorf("failed to delete item image: %w", err)
+		}
+	}
+
+	for _, img := range images {
+		img.ItemID = itemID
+		if img.ID != 0 {
+			const query = `UPDATE item_images SET position = ?, is_cover = ? WHERE id = ?`
+			if _, err := tx.ExecContext(ctx, query, img.Position, img.IsCover, img.ID); err != nil {
+				return fmt.Errorf("failed to update item image: %w", err)
+			}
+			continue
+		}
+		const query = `
+            INSERT INTO item_images (item_id, image_name, position, is_cover) VALUES (?, ?, ?, ?)
+            RETURNING id
+        `
+		row := tx.QueryRowContext(ctx, query, itemID, img.ImageName, img.Position, img.IsCover)
+		if err := row.Scan(&img.ID); err != nil {
+			return fmt.Errorf("failed to insert item image: %w", err)
+		}
+	}
+	return nil
+}
+
+// modifyImages changes the images of an item with modify in a transaction,
+// and updates the cover image name and the update time of the item.
+func (i *itemRepository) modifyImages(ctx context.Context, itemID int, modify func(images []*ItemImage) ([]*ItemImage, error)) (e error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := modifyImagesTx(ctx, tx, itemID, modify); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// modifyImagesTx is modifyImages in the transaction of the caller.
+func modifyImagesTx(ctx context.Context, tx *sql.Tx, itemID int, modify func(images []*ItemImage) ([]*ItemImage, error)) error {
+	item := &Item{ID: itemID}
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM items WHERE id = ?`, itemID).Scan(&item.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errItemNotFound
+		}
+		return fmt.Errorf("failed to select item: %w", err)
+	}
+	if err := loadImages(ctx, tx, []*Item{item}); err != nil {
+		return err
+	}
+
+	images, err := modify(slices.Clone(item.Images))
+	if err != nil {
+		return err
+	}
+	if len(images) > maxItemImages {
+		return errTooManyImages
+	}
+	cover := arrangeImages(images)
+	if err := syncImages(ctx, tx, itemID, item.Images, images); err != nil {
+		return err
+	}
+
+	const query = `UPDATE items SET image_name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, query, cover, itemID); err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	return nil
+}
+
+// AddImages appends images to an item. The first image becomes the cover if the item has no images.
+func (i *itemRepository) AddImages(ctx context.Context, itemID int, imageNames []string) error {
+	return i.modifyImages(ctx, itemID, func(images []*ItemImage) ([]*ItemImage, error) {
+		for _, name := range imageNames {
+			images = append(images, &ItemImage{ImageName: name})
+		}
+		return images, nil
+	})
+}
+
+// ReplaceImages replaces all the images of an item. The first image becomes the cover.
+func (i *itemRepository) ReplaceImages(ctx context.Context, itemID int, imageNames []string) error {
+	return i.modifyImages(ctx, itemID, replaceImagesWith(imageNames))
+}
+
+// replaceImagesWith returns the modification of images to replace all of them with the new images.
+func replaceImagesWith(imageNames []string) func([]*ItemImage) ([]*ItemImage, error) {
+	return func([]*ItemImage) ([]*ItemImage, error) {
+		images := make([]*ItemImage, 0, len(imageNames))
+		for _, name := range imageNames {
+			images = append(images, &ItemImage{ImageName: name})
+		}
+		return images, nil
+	}
+}
+
+// DeleteImage removes an image from an item. If it was the cover, the first remaining image becomes the cover.
+func (i *itemRepository) DeleteImage(ctx context.Context, itemID, imageID int) error {
+	return i.modifyImages(ctx, itemID, func(images []*ItemImage) ([]*ItemImage, error) {
+		idx := slices.IndexFunc(images, func(img *ItemImage) bool { return img.ID == imageID })
+		if idx < 0 {
+			return nil, errItemImageNotFound
+		}
+		return slices.Delete(images, idx, idx+1), nil
+	})
+}
+
+// ReorderImages orders the images of an item as imageIDs, which must list all of them.
+// coverID chooses the cover image. 0 keeps the current cover.
+func (i *itemRepository) ReorderImages(ctx context.Context, itemID int, imageIDs []int, coverID int) error {
+	return i.modifyImages(ctx, itemID, func(images []*ItemImage) ([]*ItemImage, error) {
+		if len(imageIDs) != len(images) {
+			return nil, errInvalidImageOrder
+		}
+		byID := make(map[int]*ItemImage, len(images))
+		for _, img := range images {
+			byID[img.ID] = img
+		}
+		if _, ok := byID[coverID]; coverID != 0 && !ok {
+			return nil, errItemImageNotFound
+		}
+
+		ordered := make([]*ItemImage, 0, len(images))
+		for _, id := range imageIDs {
+			img, ok := byID[id]
+			if !ok {
+				return nil, errInvalidImageOrder
+			}
+			// a duplicated id leaves another image unlisted
+			delete(byID, id)
+			if coverID != 0 {
+				img.IsCover = img.ID == coverID
+			}
+			ordered = append(ordered, img)
+		}
+		return ordered, nil
+	})
+}
+
+// ImageNames returns the names of all the images referred to by items without duplicates.
+func (i *itemRepository) ImageNames(ctx context.Context) ([]string, error) {
 	const query = `
-        SELECT i.id, i.name, i.category_id, c.name as category_name, i.image_name 
-        FROM items i
+        SELECT image_name FROM items WHERE image_name IS NOT NULL AND image_name != ''
+        UNION
+        SELECT image_name FROM item_images`
+	rows, err := i.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query image names: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan image name: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate image names: %w", err)
+	}
+	return names, nil
+}
+
+// IsImageReferenced reports whether any item refers to the image, including by a name with
+// the legacy directory such as images/<name>.
+func (i *itemRepository) IsImageReferenced(ctx context.Context, name string) (bool, error) {
+	const query = `
+        SELECT EXISTS (
+            SELECT 1 FROM items WHERE image_name = ? OR image_name LIKE ? ESCAPE '\'
+            UNION ALL
+            SELECT 1 FROM item_images WHERE image_name = ? OR image_name LIKE ? ESCAPE '\'
+        )`
+	legacy := "%/" + escapeLike(name)
+	var referenced bool
+	if err := i.db.QueryRowContext(ctx, query, name, legacy, name, legacy).Scan(&referenced); err != nil {
+		return false, fmt.Errorf("failed to check image references: %w", err)
+	}
+	return referenced, nil
+}
+
+// SearchByKeyword searches items matching the query ordered by relevance.
+// The query consists of words, which are all required to match, and phrases enclosed in double quotes.
+// A word ending with * matches any word starting with it.
+// When the full-text search index is not available, it falls back to substring matching.
+func (i *itemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*SearchResult, error) {
+	terms := parseSearchQuery(keyword)
+	if len(terms) == 0 {
+		return nil, errEmptySearchQuery
+	}
+	if !i.hasSearchIndex {
+		return i.searchByLike(ctx, terms)
+	}
+
+	// weight matches in item names more than those in category names
+	const query = `
+        SELECT ` + itemColumns + `,
+               snippet(items_fts, -1, char(2), char(3), '…', 16) AS snippet,
+               bm25(items_fts, 10.0, 1.0) AS rank
+        FROM items_fts
+        JOIN items i ON i.id = items_fts.rowid
         JOIN categories c ON i.category_id = c.id
-        WHERE i.name LIKE ?
+        WHERE items_fts MATCH ? AND i.hidden_at IS NULL
+        ORDER BY rank, i.id DESC
     `
-	searchPattern := "%" + keyword + "%"
+	rows, err := i.db.QueryContext(ctx, query, buildMatchQuery(terms))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query items: %w", err)
+	}
+	defer rows.Close()
 
-	rows, err := i.db.QueryContext(ctx, query, searchPattern)
+	var results []*SearchResult
+	for rows.Next() {
+		var res SearchResult
+		var rank float64
+		if err := rows.Scan(append(itemScanDest(&res.Item), &res.Snippet, &rank)...); err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+		res.Snippet = highlightSnippet(res.Snippet)
+		// bm25 returns smaller values for better matches
+		res.Score = -rank
+		results = append(results, &res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error: %w", err)
+	}
+	if err := loadSearchResultImages(ctx, i.db, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// searchByLike searches items whose name or category name contains all the terms.
+func (i *itemRepository) searchByLike(ctx context.Context, terms []searchTerm) ([]*SearchResult, error) {
+	var conds []string
+	var args []any
+	for _, t := range terms {
+		conds = append(conds, `(i.name LIKE ? ESCAPE '\' OR c.name LIKE ? ESCAPE '\')`)
+		pattern := "%" + escapeLike(t.text) + "%"
+		args = append(args, pattern, pattern)
+	}
+	conds = append(conds, "i.hidden_at IS NULL")
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        WHERE ` + strings.Join(conds, " AND ") + `
+        ORDER BY i.id DESC
+    `
+	rows, err := i.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query items: %w", err)
 	}
 	defer rows.Close()
 
-	var items []*Item
+	var results []*SearchResult
 	for rows.Next() {
-		var it Item
-		var categoryName string
-		if err := rows.Scan(&it.ID, &it.Name, &it.CategoryID, &categoryName, &it.ImageName); err != nil {
+		var res SearchResult
+		if err := rows.Scan(itemScanDest(&res.Item)...); err != nil {
 			return nil, fmt.Errorf("failed to scan item: %w", err)
 		}
-		it.Category = categoryName
-		items = append(items, &it)
+		res.Snippet = html.EscapeString(res.Name)
+		results = append(results, &res)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row error: %w", err)
 	}
-	return items, nil
+	if err := loadSearchResultImages(ctx, i.db, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func loadSearchResultImages(ctx context.Context, q queryer, results []*SearchResult) error {
+	items := make([]*Item, 0, len(results))
+	for _, res := range results {
+		items = append(items, &res.Item)
+	}
+	return loadImages(ctx, q, items)
+}
+
+// searchTerm is a word or a phrase in a search query.
+type searchTerm struct {
+	text   string
+	phrase bool
+	prefix bool
+}
+
+// parseSearchQuery splits a search query into words and double-quoted phrases.
+// An unterminated quote is treated as a phrase up to the end of the query.
+func parseSearchQuery(q string) []searchTerm {
+	var terms []searchTerm
+	for q != "" {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+
+		if q[0] == '"' {
+			text, rest, _ := strings.Cut(q[1:], `"`)
+			q = rest
+			if text = strings.Join(strings.Fields(text), " "); hasSearchableRune(text) {
+				terms = append(terms, searchTerm{text: text, phrase: true})
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(q, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(q)
+		}
+		word := q[:end]
+		q = q[end:]
+
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if hasSearchableRune(word) {
+			terms = append(terms, searchTerm{text: word, prefix: prefix})
+		}
+	}
+	return terms
+}
+
+func hasSearchableRune(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) >= 0
+}
+
+// buildMatchQuery builds an FTS5 MATCH expression requiring all the terms.
+// Every term is quoted so that characters in it are never interpreted as FTS5 operators.
+func buildMatchQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		part := `"` + strings.ReplaceAll(t.text, `"`, `""`) + `"`
+		if t.prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// highlightSnippet escapes a snippet returned by FTS5 and replaces the match markers with <mark> tags.
+func highlightSnippet(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(s)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// hasSearchIndex reports whether SQLite supports FTS5 and the items_fts index exists.
+func hasSearchIndex(db *sql.DB) bool {
+	var exists bool
+	const query = `
+        SELECT sqlite_compileoption_used('ENABLE_FTS5')
+           AND EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'items_fts')
+    `
+	if err := db.QueryRow(query).Scan(&exists); err != nil {
+		return false
+	}
+	return exists
 }
 
 func (c *categoryRepository) GetOrCreate(ctx context.Context, name string) (int, error) {
@@ -178,11 +1134,25 @@ func (c *categoryRepository) GetOrCreate(ctx context.Context, name string) (int,
 	return int(lastID), nil
 }
 
+// categoryColumns are the columns of a category scanned by categoryScanDest.
+// The query must alias categories as c. The hidden items are not counted like in ListPage.
+const categoryColumns = `c.id, c.name, c.parent_id,
+    (SELECT COUNT(*) FROM items WHERE category_id = c.id AND hidden_at IS NULL) AS item_count`
+
+// categoryScanDest returns the destinations to scan categoryColumns into.
+func categoryScanDest(c *Category) []any {
+	return []any{&c.ID, &c.Name, &c.ParentID, &c.ItemCount}
+}
+
 // GetByID retrieves a category by id.
 func (c *categoryRepository) GetByID(ctx context.Context, id int) (*Category, error) {
+	return getCategory(ctx, c.db, id)
+}
+
+func getCategory(ctx context.Context, q queryer, id int) (*Category, error) {
 	var category Category
-	query := `SELECT id, name FROM categories WHERE id = ?`
-	err := c.db.QueryRowContext(ctx, query, id).Scan(&category.ID, &category.Name)
+	query := `SELECT ` + categoryColumns + ` FROM categories c WHERE c.id = ?`
+	err := q.QueryRowContext(ctx, query, id).Scan(categoryScanDest(&category)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errCategoryNotFound
@@ -192,10 +1162,454 @@ func (c *categoryRepository) GetByID(ctx context.Context, id int) (*Category, er
 	return &category, nil
 }
 
-// StoreImage stores an image file.
-func StoreImage(fileName string, image []byte) error {
-	if err := os.WriteFile(fileName, image, 0644); err != nil {
-		return fmt.Errorf("failed to write image file: %w", err)
+// GetByName retrieves a category by name.
+func (c *categoryRepository) GetByName(ctx context.Context, name string) (*Category, error) {
+	var category Category
+	query := `SELECT ` + categoryColumns + ` FROM categories c WHERE c.name = ?`
+	err := c.db.QueryRowContext(ctx, query, name).Scan(categoryScanDest(&category)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errCategoryNotFound
+		}
+		return nil, fmt.Errorf("failed to scan category: %w", err)
+	}
+	return &category, nil
+}
+
+// List retrieves all categories ordered by name.
+func (c *categoryRepository) List(ctx context.Context) ([]*Category, error) {
+	query := `SELECT ` + categoryColumns + ` FROM categories c ORDER BY c.name`
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []*Category
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(categoryScanDest(&category)...); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, &category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error: %w", err)
+	}
+	return categories, nil
+}
+
+// Create inserts a category and sets its id.
+// It returns errCategoryExists if a category with the same name exists,
+// and errParentNotFound if the parent category does not exist.
+func (c *categoryRepository) Create(ctx context.Context, category *Category) error {
+	if err := checkParent(ctx, c.db, category); err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO categories (name, parent_id) VALUES (?, ?)`
+	result, err := c.db.ExecContext(ctx, query, category.Name, category.ParentID)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return errCategoryExists
+		}
+		return fmt.Errorf("failed to insert category: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+	category.ID = int(id)
+	return nil
+}
+
+// Update updates the name and the parent of a category.
+// It returns errCategoryExists if another category has the new name,
+// errParentNotFound if the parent category does not exist,
+// and errCategoryCycle if the parent is the category itself or one of its subcategories.
+func (c *categoryRepository) Update(ctx context.Context, category *Category) error {
+	if err := checkParent(ctx, c.db, category); err != nil {
+		return err
+	}
+
+	const query = `UPDATE categories SET name = ?, parent_id = ? WHERE id = ?`
+	result, err := c.db.ExecContext(ctx, query, category.Name, category.ParentID, category.ID)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return errCategoryExists
+		}
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return errCategoryNotFound
 	}
 	return nil
 }
+
+// Merge moves the items and the subcategories of the source category into the target category,
+// and deletes the source. The target must not be the source or in its subtree.
+func (c *categoryRepository) Merge(ctx context.Context, sourceID, targetID int) (e error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// check the categories in the transaction, so that a concurrent change cannot make a cycle
+	// or delete the target before the items are moved
+	if _, err := getCategory(ctx, tx, sourceID); err != nil {
+		return err
+	}
+	// the target becomes the parent of the subcategories, so it is checked as their parent
+	if err := checkParent(ctx, tx, &Category{ID: sourceID, ParentID: &targetID}); err != nil {
+		if errors.Is(err, errParentNotFound) {
+			return fmt.Errorf("%w: merge target %d", errCategoryNotFound, targetID)
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE items SET category_id = ?, updated_at = CURRENT_TIMESTAMP WHERE category_id = ?`, targetID, sourceID); err != nil {
+		return fmt.Errorf("failed to move items: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE categories SET parent_id = ? WHERE parent_id = ?`, targetID, sourceID); err != nil {
+		return fmt.Errorf("failed to move subcategories: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id = ?`, sourceID); err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// checkParent validates that the parent of a category exists and is not in the subtree of the category.
+func checkParent(ctx context.Context, q queryer, category *Category) error {
+	if category.ParentID == nil {
+		return nil
+	}
+
+	// walk up from the parent to the root and look for the category itself
+	const query = `
+        WITH RECURSIVE ancestors (id, parent_id) AS (
+            SELECT id, parent_id FROM categories WHERE id = ?
+            UNION
+            SELECT p.id, p.parent_id FROM categories p JOIN ancestors a ON p.id = a.parent_id
+        )
+        SELECT EXISTS (SELECT 1 FROM ancestors), EXISTS (SELECT 1 FROM ancestors WHERE id = ?)
+    `
+	var parentExists, cycle bool
+	if err := q.QueryRowContext(ctx, query, *category.ParentID, category.ID).Scan(&parentExists, &cycle); err != nil {
+		return fmt.Errorf("failed to query parent category: %w", err)
+	}
+	if !parentExists {
+		return errParentNotFound
+	}
+	if cycle {
+		return errCategoryCycle
+	}
+	return nil
+}
+
+// Delete deletes a category by id.
+// It returns errCategoryInUse if any item or subcategory belongs to the category.
+func (c *categoryRepository) Delete(ctx context.Context, id int) error {
+	const query = `
+        DELETE FROM categories
+        WHERE id = ?
+          AND NOT EXISTS (SELECT 1 FROM items WHERE category_id = categories.id)
+          AND NOT EXISTS (SELECT 1 FROM categories child WHERE child.parent_id = categories.id)
+    `
+	result, err := c.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// tell whether the category does not exist or is in use
+	if _, err := c.GetByID(ctx, id); err != nil {
+		return err
+	}
+	return errCategoryInUse
+}
+
+// userColumns are the columns of a user scanned by userScanDest.
+const userColumns = `id, email, name, role, password_hash, created_at`
+
+func userScanDest(u *User) []any {
+	return []any{&u.ID, &u.Email, &u.Name, &u.Role, &u.PasswordHash, &u.CreatedAt}
+}
+
+// Create inserts a user with the default role, and sets its id, role and creation time.
+// It returns errUserExists if a user with the same email exists.
+func (u *userRepository) Create(ctx context.Context, user *User) error {
+	user.Email = strings.ToLower(user.Email)
+	const query = `
+        INSERT INTO users (email, name, password_hash) VALUES (?, ?, ?)
+        RETURNING id, role, created_at
+    `
+	row := u.db.QueryRowContext(ctx, query, user.Email, user.Name, user.PasswordHash)
+	if err := row.Scan(&user.ID, &user.Role, &user.CreatedAt); err != nil {
+		if isUniqueConstraintError(err) {
+			return errUserExists
+		}
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+	return nil
+}
+
+// GetByID retrieves a user by id.
+func (u *userRepository) GetByID(ctx context.Context, id int) (*User, error) {
+	return u.get(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+}
+
+// GetByEmail retrieves a user by email regardless of its case.
+func (u *userRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return u.get(ctx, `SELECT `+userColumns+` FROM users WHERE email = ?`, strings.ToLower(email))
+}
+
+// List retrieves all the users in the order of registration.
+func (u *userRepository) List(ctx context.Context) ([]*User, error) {
+	rows, err := u.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(userScanDest(&user)...); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error: %w", err)
+	}
+	return users, nil
+}
+
+// SetRole changes the role of a user. It returns errUnknownRole if the role is not in the roles table.
+func (u *userRepository) SetRole(ctx context.Context, id int, role Role) error {
+	const query = `UPDATE users SET role = ? WHERE id = ? AND EXISTS (SELECT 1 FROM roles WHERE name = ?)`
+	result, err := u.db.ExecContext(ctx, query, role, id, role)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// tell whether the user or the role does not exist
+	if _, err := u.GetByID(ctx, id); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", errUnknownRole, role)
+}
+
+func (u *userRepository) get(ctx context.Context, query string, args ...any) (*User, error) {
+	var user User
+	if err := u.db.QueryRowContext(ctx, query, args...).Scan(userScanDest(&user)...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errUserNotFound
+		}
+		return nil, fmt.Errorf("failed to scan user: %w", err)
+	}
+	return &user, nil
+}
+
+// sessionColumns are the columns of a session scanned by sessionScanDest.
+const sessionColumns = `id, user_id, family_id, access_token_hash, refresh_token_hash, access_expires_at, refresh_expires_at, created_at`
+
+func sessionScanDest(s *Session) []any {
+	return []any{&s.ID, &s.UserID, &s.FamilyID, &s.AccessTokenHash, &s.RefreshTokenHash, &s.AccessExpiresAt, &s.RefreshExpiresAt, &s.CreatedAt}
+}
+
+// Create inserts a session and sets its id and creation time.
+func (s *sessionRepository) Create(ctx context.Context, session *Session) error {
+	return insertSession(ctx, s.db, session)
+}
+
+func insertSession(ctx context.Context, q queryer, session *Session) error {
+	const query = `
+        INSERT INTO sessions (user_id, family_id, access_token_hash, refresh_token_hash, access_expires_at, refresh_expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        RETURNING id, created_at
+    `
+	row := q.QueryRowContext(ctx, query, session.UserID, session.FamilyID, session.AccessTokenHash, session.RefreshTokenHash,
+		session.AccessExpiresAt.UTC(), session.RefreshExpiresAt.UTC())
+	if err := row.Scan(&session.ID, &session.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert session: %w", err)
+	}
+	return nil
+}
+
+// GetByAccessToken retrieves the session of the access token, unless the session is rotated or revoked.
+// The caller checks whether the access token is expired.
+func (s *sessionRepository) GetByAccessToken(ctx context.Context, accessTokenHash string) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE access_token_hash = ? AND rotated_at IS NULL AND revoked_at IS NULL`
+	var session Session
+	if err := s.db.QueryRowContext(ctx, query, accessTokenHash).Scan(sessionScanDest(&session)...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to scan session: %w", err)
+	}
+	return &session, nil
+}
+
+// Rotate replaces the session of the refresh token with next, which takes over the user and the family.
+// It returns errSessionNotFound if the session does not exist, is revoked or its refresh token is expired.
+// If the session is already rotated, the refresh token is being reused, so it revokes the whole family
+// and returns errRefreshTokenReused.
+func (s *sessionRepository) Rotate(ctx context.Context, refreshTokenHash string, next *Session, now time.Time) (e error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		// rolling back after the revocation is committed does nothing
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var (
+		current   Session
+		rotatedAt sql.NullTime
+		revokedAt sql.NullTime
+	)
+	query := `SELECT ` + sessionColumns + `, rotated_at, revoked_at FROM sessions WHERE refresh_token_hash = ?`
+	dest := append(sessionScanDest(&current), &rotatedAt, &revokedAt)
+	if err := tx.QueryRowContext(ctx, query, refreshTokenHash).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errSessionNotFound
+		}
+		return fmt.Errorf("failed to scan session: %w", err)
+	}
+	if revokedAt.Valid || !now.Before(current.RefreshExpiresAt) {
+		return errSessionNotFound
+	}
+	if rotatedAt.Valid {
+		if err := revokeFamily(ctx, tx, current.FamilyID, now); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return errRefreshTokenReused
+	}
+
+	// the condition on rotated_at keeps a concurrent refresh with the same token from rotating it twice
+	result, err := tx.ExecContext(ctx, `UPDATE sessions SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL`, now.UTC(), current.ID)
+	if err != nil {
+		return fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	} else if n == 0 {
+		return errSessionNotFound
+	}
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	if err := insertSession(ctx, tx, next); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RevokeFamily revokes all the sessions refreshed from the same login, which logs the user out.
+func (s *sessionRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return revokeFamily(ctx, s.db, familyID, time.Now())
+}
+
+func revokeFamily(ctx context.Context, q queryer, familyID string, now time.Time) error {
+	const query = `UPDATE sessions SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`
+	if _, err := q.ExecContext(ctx, query, now.UTC(), familyID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// HasPermission reports whether the role is granted the permission.
+func (r *roleRepository) HasPermission(ctx context.Context, role Role, permission Permission) (bool, error) {
+	const query = `SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = ? AND permission = ?)`
+	var ok bool
+	if err := r.db.QueryRowContext(ctx, query, role, permission).Scan(&ok); err != nil {
+		return false, fmt.Errorf("failed to query permission: %w", err)
+	}
+	return ok, nil
+}
+
+// Record inserts an audit event and sets its id and creation time.
+func (a *auditRepository) Record(ctx context.Context, event *AuditEvent) error {
+	const query = `
+        INSERT INTO audit_events (user_id, action, permission, outcome, request_id, remote_addr)
+        VALUES (?, ?, ?, ?, ?, ?)
+        RETURNING id, created_at
+    `
+	row := a.db.QueryRowContext(ctx, query, event.UserID, event.Action, event.Permission, event.Outcome, event.RequestID, event.RemoteAddr)
+	if err := row.Scan(&event.ID, &event.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert audit event: %w", err)
+	}
+	return nil
+}
+
+// isUniqueConstraintError reports whether err is a violation of a UNIQUE constraint.
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// StoredImage is an image read from an ImageStore. The caller must close it.
+type StoredImage struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// ImageInfo describes an image stored in an ImageStore.
+type ImageInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ImageStore stores image files by name. Names may contain slashes to group files,
+// such as variants/<name>, but must not escape the store.
+type ImageStore interface {
+	// Put stores the image read from r as name, replacing an existing image atomically.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get returns the image stored as name, or errImageNotFound.
+	Get(ctx context.Context, name string) (*StoredImage, error)
+	// Exists reports whether an image is stored as name.
+	Exists(ctx context.Context, name string) (bool, error)
+	// Delete removes the image stored as name. Deleting a missing image is not an error.
+	Delete(ctx context.Context, name string) error
+	// List returns all the stored images, including the ones grouped in directories.
+	List(ctx context.Context) ([]ImageInfo, error)
+	// URL returns the URL where clients fetch the image. A URL starting with / is relative to this server.
+	URL(name string) string
+}
@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"mercari-build-training/db/schema"
+)
+
+var errUnknownMigration = errors.New("unknown migration version")
+
+// Migration is a versioned change of the database schema.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the change.
+	Up string
+	// Down reverts the change.
+	Down string
+}
+
+// MigrationStatus is the state of a migration in a database.
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is the time when the migration was applied. It is nil if the migration is pending.
+	AppliedAt *time.Time
+}
+
+// migrationFileName matches <version>_<name>.up.sql and <version>_<name>.down.sql .
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// LoadMigrations loads the migrations in dir of fsys ordered by version.
+// Every migration must have both an up file and a down file.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := migrationFileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s: %w", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", e.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations and records the applied versions in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	const query = `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `
+	if _, err := m.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// Status returns the state of all the known migrations ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error: %w", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := MigrationStatus{Migration: mig}
+		if at, ok := applied[mig.Version]; ok {
+			st.AppliedAt = &at
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, st)
+	}
+	for version := range applied {
+		// the database was migrated by a newer version of the application
+		return nil, fmt.Errorf("%w: %d is applied to the database", errUnknownMigration, version)
+	}
+	return statuses, nil
+}
+
+// Version returns the latest applied version. It returns 0 if no migrations are applied.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, st := range statuses {
+		if st.AppliedAt != nil {
+			version = st.Version
+		}
+	}
+	return version, nil
+}
+
+// Up applies all the pending migrations and returns the number of applied migrations.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if len(m.migrations) == 0 {
+		return 0, nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the latest n applied migrations and returns the number of reverted migrations.
+func (m *Migrator) Down(ctx context.Context, n int) (reverted int, e error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if reverted > 0 {
+			e = errors.Join(e, m.restoreSearchIndex(ctx))
+		}
+	}()
+
+	for i := len(statuses) - 1; i >= 0 && reverted < n; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		if err := m.apply(ctx, statuses[i].Migration, false); err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// To applies or reverts migrations so that the migrations up to version are applied and the rest are not.
+// Version 0 reverts all the migrations. It returns the number of applied or reverted migrations.
+func (m *Migrator) To(ctx context.Context, version int) (count int, e error) {
+	if version != 0 && !m.known(version) {
+		return 0, fmt.Errorf("%w: %d", errUnknownMigration, version)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if count > 0 {
+			e = errors.Join(e, m.restoreSearchIndex(ctx))
+		}
+	}()
+
+	// revert newer migrations from the latest
+	for i := len(statuses) - 1; i >= 0; i-- {
+		st := statuses[i]
+		if st.Version > version && st.AppliedAt != nil {
+			if err := m.apply(ctx, st.Migration, false); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	// apply older migrations from the oldest
+	for _, st := range statuses {
+		if st.Version <= version && st.AppliedAt == nil {
+			if err := m.apply(ctx, st.Migration, true); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreSearchIndex recreates the search index triggers dropped by apply. When the migrations
+// reverted the items table, the index is dropped instead since there is nothing left to index.
+func (m *Migrator) restoreSearchIndex(ctx context.Context) error {
+	var n int
+	if err := m.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('items', 'categories')`).Scan(&n); err != nil {
+		return fmt.Errorf("failed to check tables: %w", err)
+	}
+	if n < 2 {
+		if _, err := m.db.ExecContext(ctx, `DROP TABLE IF EXISTS items_fts`); err != nil {
+			return fmt.Errorf("failed to drop search index: %w", err)
+		}
+		return nil
+	}
+	if err := setupSearchIndex(ctx, m.db); err != nil {
+		return fmt.Errorf("failed to set up search index: %w", err)
+	}
+	return nil
+}
+
+// apply runs the up or down script of a migration and records it in a transaction.
+func (m *Migrator) apply(ctx context.Context, mig Migration, up bool) (e error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// the search index is derived from items and categories, and its triggers
+	// would block rebuilding those tables. they are recreated by restoreSearchIndex.
+	if err := dropSearchIndexTriggers(ctx, tx); err != nil {
+		return err
+	}
+
+	direction, script := "up", mig.Up
+	record, args := `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, []any{mig.Version, mig.Name}
+	if !up {
+		direction, script = "down", mig.Down
+		record, args = `DELETE FROM schema_migrations WHERE version = ?`, []any{mig.Version}
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("failed to migrate %s %d_%s: %w", direction, mig.Version, mig.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return nil
+}
+
+// RunMigrateCommand runs the migrate subcommand against the database at dbPath and returns the exit code.
+//
+//	migrate status          shows the state of every migration
+//	migrate up              applies all the pending migrations
+//	migrate down [n]        reverts the latest n migrations (default 1)
+//	migrate to <version>    migrates up or down to the version (0 reverts all)
+func RunMigrateCommand(dbPath string, args []string, stdout io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "usage: migrate status | up | down [n] | to <version>")
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		slog.Error("failed to open DB", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrations, err := LoadMigrations(schema.Migrations, "migrations")
+	if err != nil {
+		slog.Error("failed to load migrations", "error", err)
+		return 1
+	}
+	m := NewMigrator(db, migrations)
+
+	var count int
+	switch cmd, rest := args[0], args[1:]; {
+	case cmd == "status" && len(rest) == 0:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			slog.Error("failed to get migration status", "error", err)
+			return 1
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.AppliedAt != nil {
+				state = "applied at " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(stdout, "%04d_%s\t%s\n", st.Version, st.Name, state)
+		}
+		return 0
+	case cmd == "up" && len(rest) == 0:
+		count, err = m.Up(ctx)
+	case cmd == "down" && len(rest) <= 1:
+		n := 1
+		if len(rest) == 1 {
+			if n, err = strconv.Atoi(rest[0]); err != nil || n < 1 {
+				fmt.Fprintln(stdout, "down takes a positive number of migrations")
+				return 2
+			}
+		}
+		count, err = m.Down(ctx, n)
+	case cmd == "to" && len(rest) == 1:
+		version, convErr := strconv.Atoi(rest[0])
+		if convErr != nil {
+			fmt.Fprintln(stdout, "to takes a version number")
+			return 2
+		}
+		count, err = m.To(ctx, version)
+	default:
+		fmt.Fprintln(stdout, "usage: migrate status | up | down [n] | to <version>")
+		return 2
+	}
+	if err != nil {
+		slog.Error("failed to migrate database", "error", err, "migrated", count)
+		return 1
+	}
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		slog.Error("failed to get schema version", "error", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "migrated %d migrations, schema version is %d\n", count, version)
+	return 0
+}
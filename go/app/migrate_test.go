@@ -0,0 +1,269 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+
+	"mercari-build-training/db/schema"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		fsys fstest.MapFS
+		want []int
+		err  bool
+	}{
+		"ok: ordered by version": {
+			fsys: fstest.MapFS{
+				"m/0010_b.up.sql":   {Data: []byte("b up")},
+				"m/0010_b.down.sql": {Data: []byte("b down")},
+				"m/0002_a.up.sql":   {Data: []byte("a up")},
+				"m/0002_a.down.sql": {Data: []byte("a down")},
+				"m/README.md":       {Data: []byte("not a migration")},
+			},
+			want: []int{2, 10},
+		},
+		"ng: missing down": {
+			fsys: fstest.MapFS{
+				"m/0001_a.up.sql": {Data: []byte("a up")},
+			},
+			err: true,
+		},
+		"ng: different names for a version": {
+			fsys: fstest.MapFS{
+				"m/0001_a.up.sql":   {Data: []byte("a up")},
+				"m/0001_b.down.sql": {Data: []byte("b down")},
+			},
+			err: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			migrations, err := LoadMigrations(tt.fsys, "m")
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatal("expected an error")
+			}
+			var got []int
+			for _, m := range migrations {
+				got = append(got, m.Version)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected versions (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMigratorE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := t.Context()
+	migrations, err := LoadMigrations(schema.Migrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].Version
+	m := NewMigrator(db, migrations)
+
+	assertVersion := func(t *testing.T, want int) {
+		t.Helper()
+		got, err := m.Version(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected schema version %d, got %d", want, got)
+		}
+	}
+
+	// the triggers dropped while migrating are recreated when the search index is available
+	assertSearchTriggers := func(t *testing.T) {
+		t.Helper()
+		want := 0
+		if hasSearchIndex(db) {
+			want = len(searchIndexTriggers)
+		}
+		var got int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'items_fts_%'`).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected %d search index triggers, got %d", want, got)
+		}
+	}
+
+	if err := setupDatabase(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, latest)
+
+	categoryID, err := NewCategoryRepository(db).GetOrCreate(ctx, "fashion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewItemRepository(db).Insert(ctx, &Item{Name: "jacket", CategoryID: categoryID}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("down and up again keeps the data", func(t *testing.T) {
+		if _, err := m.Down(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		assertVersion(t, latest-1)
+		assertSearchTriggers(t)
+
+		if _, err := m.To(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		assertVersion(t, 1)
+
+		if err := setupDatabase(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		assertVersion(t, latest)
+
+		item, err := NewItemRepository(db).Select(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Name != "jacket" || item.Category != "fashion" {
+			t.Errorf("unexpected item after migrations: %+v", item)
+		}
+	})
+
+	t.Run("down to zero drops the tables", func(t *testing.T) {
+		if _, err := m.To(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+		assertVersion(t, 0)
+
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN ('items', 'categories')`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("expected the tables to be dropped, %d remain", n)
+		}
+		if hasSearchIndex(db) {
+			t.Error("expected the search index to be dropped")
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		if _, err := m.To(ctx, latest+1); !errors.Is(err, errUnknownMigration) {
+			t.Errorf("expected %v, got %v", errUnknownMigration, err)
+		}
+	})
+}
+
+func TestMigrateLegacyDatabaseE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// a database created by the former items.sql has no schema_migrations table
+	const legacy = `
+	CREATE TABLE items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		category_id INTEGER NOT NULL,
+		image_name TEXT,
+		FOREIGN KEY (category_id) REFERENCES categories(id)
+	);
+	CREATE TABLE categories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE
+	);
+	INSERT INTO categories (name) VALUES ('phone');
+	INSERT INTO items (name, category_id, image_name) VALUES ('used iPhone 16e', 1, 'images/a.jpg');
+	`
+	if _, err := db.Exec(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.Context()
+	if err := setupDatabase(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := NewItemRepository(db).Select(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "used iPhone 16e" || item.Category != "phone" || item.CreatedAt.IsZero() {
+		t.Errorf("unexpected item after migrations: %+v", item)
+	}
+	// the image becomes the cover of the item images
+	if len(item.Images) != 1 || item.Images[0].ImageName != "images/a.jpg" || !item.Images[0].IsCover {
+		t.Errorf("unexpected item images after migrations: %+v", item.Images)
+	}
+}
+
+func TestRunMigrateCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "test.sqlite3")
+
+	cases := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{args: []string{"status"}, code: 0, want: "0001_create_items_and_categories\tpending"},
+		{args: []string{"up"}, code: 0, want: "schema version is "},
+		{args: []string{"down", "2"}, code: 0, want: "migrated 2 migrations"},
+		{args: []string{"to", "1"}, code: 0, want: "schema version is 1"},
+		{args: []string{"down", "zero"}, code: 2, want: "positive number"},
+		{args: []string{"sideways"}, code: 2, want: "usage"},
+	}
+
+	for _, tt := range cases {
+		var out bytes.Buffer
+		code := RunMigrateCommand(dbPath, tt.args, &out)
+		if code != tt.code {
+			t.Errorf("migrate %v: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("migrate %v: expected output to contain %q, got %q", tt.args, tt.want, out.String())
+		}
+	}
+
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("expected the database to be created: %v", err)
+	}
+}
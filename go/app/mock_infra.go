@@ -11,7 +11,10 @@ package app
 
 import (
 	context "context"
+	sql "database/sql"
+	io "io"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -40,6 +43,63 @@ func (m *MockItemRepository) EXPECT() *MockItemRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AddImages mocks base method.
+func (m *MockItemRepository) AddImages(ctx context.Context, itemID int, imageNames []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddImages", ctx, itemID, imageNames)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddImages indicates an expected call of AddImages.
+func (mr *MockItemRepositoryMockRecorder) AddImages(ctx, itemID, imageNames any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddImages", reflect.TypeOf((*MockItemRepository)(nil).AddImages), ctx, itemID, imageNames)
+}
+
+// Delete mocks base method.
+func (m *MockItemRepository) Delete(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockItemRepositoryMockRecorder) Delete(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockItemRepository)(nil).Delete), ctx, id)
+}
+
+// DeleteImage mocks base method.
+func (m *MockItemRepository) DeleteImage(ctx context.Context, itemID, imageID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteImage", ctx, itemID, imageID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteImage indicates an expected call of DeleteImage.
+func (mr *MockItemRepositoryMockRecorder) DeleteImage(ctx, itemID, imageID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteImage", reflect.TypeOf((*MockItemRepository)(nil).DeleteImage), ctx, itemID, imageID)
+}
+
+// ImageNames mocks base method.
+func (m *MockItemRepository) ImageNames(ctx context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageNames", ctx)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageNames indicates an expected call of ImageNames.
+func (mr *MockItemRepositoryMockRecorder) ImageNames(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageNames", reflect.TypeOf((*MockItemRepository)(nil).ImageNames), ctx)
+}
+
 // Insert mocks base method.
 func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
 	m.ctrl.T.Helper()
@@ -54,6 +114,21 @@ func (mr *MockItemRepositoryMockRecorder) Insert(ctx, item any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockItemRepository)(nil).Insert), ctx, item)
 }
 
+// IsImageReferenced mocks base method.
+func (m *MockItemRepository) IsImageReferenced(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsImageReferenced", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsImageReferenced indicates an expected call of IsImageReferenced.
+func (mr *MockItemRepositoryMockRecorder) IsImageReferenced(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsImageReferenced", reflect.TypeOf((*MockItemRepository)(nil).IsImageReferenced), ctx, name)
+}
+
 // List mocks base method.
 func (m *MockItemRepository) List(ctx context.Context) ([]*Item, error) {
 	m.ctrl.T.Helper()
@@ -69,11 +144,54 @@ func (mr *MockItemRepositoryMockRecorder) List(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockItemRepository)(nil).List), ctx)
 }
 
+// ListPage mocks base method.
+func (m *MockItemRepository) ListPage(ctx context.Context, params ListItemsParams) (*ItemPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPage", ctx, params)
+	ret0, _ := ret[0].(*ItemPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPage indicates an expected call of ListPage.
+func (mr *MockItemRepositoryMockRecorder) ListPage(ctx, params any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPage", reflect.TypeOf((*MockItemRepository)(nil).ListPage), ctx, params)
+}
+
+// ReorderImages mocks base method.
+func (m *MockItemRepository) ReorderImages(ctx context.Context, itemID int, imageIDs []int, coverID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReorderImages", ctx, itemID, imageIDs, coverID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReorderImages indicates an expected call of ReorderImages.
+func (mr *MockItemRepositoryMockRecorder) ReorderImages(ctx, itemID, imageIDs, coverID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReorderImages", reflect.TypeOf((*MockItemRepository)(nil).ReorderImages), ctx, itemID, imageIDs, coverID)
+}
+
+// ReplaceImages mocks base method.
+func (m *MockItemRepository) ReplaceImages(ctx context.Context, itemID int, imageNames []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceImages", ctx, itemID, imageNames)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceImages indicates an expected call of ReplaceImages.
+func (mr *MockItemRepositoryMockRecorder) ReplaceImages(ctx, itemID, imageNames any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceImages", reflect.TypeOf((*MockItemRepository)(nil).ReplaceImages), ctx, itemID, imageNames)
+}
+
 // SearchByKeyword mocks base method.
-func (m *MockItemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error) {
+func (m *MockItemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*SearchResult, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SearchByKeyword", ctx, keyword)
-	ret0, _ := ret[0].([]*Item)
+	ret0, _ := ret[0].([]*SearchResult)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -99,6 +217,34 @@ func (mr *MockItemRepositoryMockRecorder) Select(ctx, id any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Select", reflect.TypeOf((*MockItemRepository)(nil).Select), ctx, id)
 }
 
+// SetHidden mocks base method.
+func (m *MockItemRepository) SetHidden(ctx context.Context, id int, hidden bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHidden", ctx, id, hidden)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHidden indicates an expected call of SetHidden.
+func (mr *MockItemRepositoryMockRecorder) SetHidden(ctx, id, hidden any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHidden", reflect.TypeOf((*MockItemRepository)(nil).SetHidden), ctx, id, hidden)
+}
+
+// Update mocks base method.
+func (m *MockItemRepository) Update(ctx context.Context, item *Item, imageNames []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, item, imageNames)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockItemRepositoryMockRecorder) Update(ctx, item, imageNames any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockItemRepository)(nil).Update), ctx, item, imageNames)
+}
+
 // MockCategoryRepository is a mock of CategoryRepository interface.
 type MockCategoryRepository struct {
 	ctrl     *gomock.Controller
@@ -123,6 +269,34 @@ func (m *MockCategoryRepository) EXPECT() *MockCategoryRepositoryMockRecorder {
 	return m.recorder
 }
 
+// Create mocks base method.
+func (m *MockCategoryRepository) Create(ctx context.Context, category *Category) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, category)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockCategoryRepositoryMockRecorder) Create(ctx, category any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockCategoryRepository)(nil).Create), ctx, category)
+}
+
+// Delete mocks base method.
+func (m *MockCategoryRepository) Delete(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockCategoryRepositoryMockRecorder) Delete(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockCategoryRepository)(nil).Delete), ctx, id)
+}
+
 // GetByID mocks base method.
 func (m *MockCategoryRepository) GetByID(ctx context.Context, id int) (*Category, error) {
 	m.ctrl.T.Helper()
@@ -138,6 +312,21 @@ func (mr *MockCategoryRepositoryMockRecorder) GetByID(ctx, id any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockCategoryRepository)(nil).GetByID), ctx, id)
 }
 
+// GetByName mocks base method.
+func (m *MockCategoryRepository) GetByName(ctx context.Context, name string) (*Category, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByName", ctx, name)
+	ret0, _ := ret[0].(*Category)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByName indicates an expected call of GetByName.
+func (mr *MockCategoryRepositoryMockRecorder) GetByName(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByName", reflect.TypeOf((*MockCategoryRepository)(nil).GetByName), ctx, name)
+}
+
 // GetOrCreate mocks base method.
 func (m *MockCategoryRepository) GetOrCreate(ctx context.Context, name string) (int, error) {
 	m.ctrl.T.Helper()
@@ -152,3 +341,495 @@ func (mr *MockCategoryRepositoryMockRecorder) GetOrCreate(ctx, name any) *gomock
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrCreate", reflect.TypeOf((*MockCategoryRepository)(nil).GetOrCreate), ctx, name)
 }
+
+// List mocks base method.
+func (m *MockCategoryRepository) List(ctx context.Context) ([]*Category, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]*Category)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockCategoryRepositoryMockRecorder) List(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockCategoryRepository)(nil).List), ctx)
+}
+
+// Merge mocks base method.
+func (m *MockCategoryRepository) Merge(ctx context.Context, sourceID, targetID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Merge", ctx, sourceID, targetID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Merge indicates an expected call of Merge.
+func (mr *MockCategoryRepositoryMockRecorder) Merge(ctx, sourceID, targetID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Merge", reflect.TypeOf((*MockCategoryRepository)(nil).Merge), ctx, sourceID, targetID)
+}
+
+// Update mocks base method.
+func (m *MockCategoryRepository) Update(ctx context.Context, category *Category) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, category)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockCategoryRepositoryMockRecorder) Update(ctx, category any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockCategoryRepository)(nil).Update), ctx, category)
+}
+
+// MockUserRepository is a mock of UserRepository interface.
+type MockUserRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockUserRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockUserRepositoryMockRecorder is the mock recorder for MockUserRepository.
+type MockUserRepositoryMockRecorder struct {
+	mock *MockUserRepository
+}
+
+// NewMockUserRepository creates a new mock instance.
+func NewMockUserRepository(ctrl *gomock.Controller) *MockUserRepository {
+	mock := &MockUserRepository{ctrl: ctrl}
+	mock.recorder = &MockUserRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUserRepository) EXPECT() *MockUserRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockUserRepository) Create(ctx context.Context, user *User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockUserRepositoryMockRecorder) Create(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockUserRepository)(nil).Create), ctx, user)
+}
+
+// GetByEmail mocks base method.
+func (m *MockUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByEmail", ctx, email)
+	ret0, _ := ret[0].(*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByEmail indicates an expected call of GetByEmail.
+func (mr *MockUserRepositoryMockRecorder) GetByEmail(ctx, email any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByEmail", reflect.TypeOf((*MockUserRepository)(nil).GetByEmail), ctx, email)
+}
+
+// GetByID mocks base method.
+func (m *MockUserRepository) GetByID(ctx context.Context, id int) (*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByID", ctx, id)
+	ret0, _ := ret[0].(*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByID indicates an expected call of GetByID.
+func (mr *MockUserRepositoryMockRecorder) GetByID(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockUserRepository)(nil).GetByID), ctx, id)
+}
+
+// List mocks base method.
+func (m *MockUserRepository) List(ctx context.Context) ([]*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockUserRepositoryMockRecorder) List(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockUserRepository)(nil).List), ctx)
+}
+
+// SetRole mocks base method.
+func (m *MockUserRepository) SetRole(ctx context.Context, id int, role Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRole", ctx, id, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRole indicates an expected call of SetRole.
+func (mr *MockUserRepositoryMockRecorder) SetRole(ctx, id, role any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRole", reflect.TypeOf((*MockUserRepository)(nil).SetRole), ctx, id, role)
+}
+
+// MockSessionRepository is a mock of SessionRepository interface.
+type MockSessionRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockSessionRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockSessionRepositoryMockRecorder is the mock recorder for MockSessionRepository.
+type MockSessionRepositoryMockRecorder struct {
+	mock *MockSessionRepository
+}
+
+// NewMockSessionRepository creates a new mock instance.
+func NewMockSessionRepository(ctrl *gomock.Controller) *MockSessionRepository {
+	mock := &MockSessionRepository{ctrl: ctrl}
+	mock.recorder = &MockSessionRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSessionRepository) EXPECT() *MockSessionRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockSessionRepository) Create(ctx context.Context, session *Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockSessionRepositoryMockRecorder) Create(ctx, session any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionRepository)(nil).Create), ctx, session)
+}
+
+// GetByAccessToken mocks base method.
+func (m *MockSessionRepository) GetByAccessToken(ctx context.Context, accessTokenHash string) (*Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByAccessToken", ctx, accessTokenHash)
+	ret0, _ := ret[0].(*Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByAccessToken indicates an expected call of GetByAccessToken.
+func (mr *MockSessionRepositoryMockRecorder) GetByAccessToken(ctx, accessTokenHash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByAccessToken", reflect.TypeOf((*MockSessionRepository)(nil).GetByAccessToken), ctx, accessTokenHash)
+}
+
+// RevokeFamily mocks base method.
+func (m *MockSessionRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeFamily", ctx, familyID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeFamily indicates an expected call of RevokeFamily.
+func (mr *MockSessionRepositoryMockRecorder) RevokeFamily(ctx, familyID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeFamily", reflect.TypeOf((*MockSessionRepository)(nil).RevokeFamily), ctx, familyID)
+}
+
+// Rotate mocks base method.
+func (m *MockSessionRepository) Rotate(ctx context.Context, refreshTokenHash string, next *Session, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rotate", ctx, refreshTokenHash, next, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Rotate indicates an expected call of Rotate.
+func (mr *MockSessionRepositoryMockRecorder) Rotate(ctx, refreshTokenHash, next, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rotate", reflect.TypeOf((*MockSessionRepository)(nil).Rotate), ctx, refreshTokenHash, next, now)
+}
+
+// MockRoleRepository is a mock of RoleRepository interface.
+type MockRoleRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRoleRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockRoleRepositoryMockRecorder is the mock recorder for MockRoleRepository.
+type MockRoleRepositoryMockRecorder struct {
+	mock *MockRoleRepository
+}
+
+// NewMockRoleRepository creates a new mock instance.
+func NewMockRoleRepository(ctrl *gomock.Controller) *MockRoleRepository {
+	mock := &MockRoleRepository{ctrl: ctrl}
+	mock.recorder = &MockRoleRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRoleRepository) EXPECT() *MockRoleRepositoryMockRecorder {
+	return m.recorder
+}
+
+// HasPermission mocks base method.
+func (m *MockRoleRepository) HasPermission(ctx context.Context, role Role, permission Permission) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasPermission", ctx, role, permission)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasPermission indicates an expected call of HasPermission.
+func (mr *MockRoleRepositoryMockRecorder) HasPermission(ctx, role, permission any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasPermission", reflect.TypeOf((*MockRoleRepository)(nil).HasPermission), ctx, role, permission)
+}
+
+// MockAuditRepository is a mock of AuditRepository interface.
+type MockAuditRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuditRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockAuditRepositoryMockRecorder is the mock recorder for MockAuditRepository.
+type MockAuditRepositoryMockRecorder struct {
+	mock *MockAuditRepository
+}
+
+// NewMockAuditRepository creates a new mock instance.
+func NewMockAuditRepository(ctrl *gomock.Controller) *MockAuditRepository {
+	mock := &MockAuditRepository{ctrl: ctrl}
+	mock.recorder = &MockAuditRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuditRepository) EXPECT() *MockAuditRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Record mocks base method.
+func (m *MockAuditRepository) Record(ctx context.Context, event *AuditEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Record", ctx, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Record indicates an expected call of Record.
+func (mr *MockAuditRepositoryMockRecorder) Record(ctx, event any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockAuditRepository)(nil).Record), ctx, event)
+}
+
+// Mockqueryer is a mock of queryer interface.
+type Mockqueryer struct {
+	ctrl     *gomock.Controller
+	recorder *MockqueryerMockRecorder
+	isgomock struct{}
+}
+
+// MockqueryerMockRecorder is the mock recorder for Mockqueryer.
+type MockqueryerMockRecorder struct {
+	mock *Mockqueryer
+}
+
+// NewMockqueryer creates a new mock instance.
+func NewMockqueryer(ctrl *gomock.Controller) *Mockqueryer {
+	mock := &Mockqueryer{ctrl: ctrl}
+	mock.recorder = &MockqueryerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockqueryer) EXPECT() *MockqueryerMockRecorder {
+	return m.recorder
+}
+
+// ExecContext mocks base method.
+func (m *Mockqueryer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
+	ret0, _ := ret[0].(sql.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExecContext indicates an expected call of ExecContext.
+func (mr *MockqueryerMockRecorder) ExecContext(ctx, query any, args ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*Mockqueryer)(nil).ExecContext), varargs...)
+}
+
+// QueryContext mocks base method.
+func (m *Mockqueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
+	ret0, _ := ret[0].(*sql.Rows)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryContext indicates an expected call of QueryContext.
+func (mr *MockqueryerMockRecorder) QueryContext(ctx, query any, args ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*Mockqueryer)(nil).QueryContext), varargs...)
+}
+
+// QueryRowContext mocks base method.
+func (m *Mockqueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryRowContext", varargs...)
+	ret0, _ := ret[0].(*sql.Row)
+	return ret0
+}
+
+// QueryRowContext indicates an expected call of QueryRowContext.
+func (mr *MockqueryerMockRecorder) QueryRowContext(ctx, query any, args ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRowContext", reflect.TypeOf((*Mockqueryer)(nil).QueryRowContext), varargs...)
+}
+
+// MockImageStore is a mock of ImageStore interface.
+type MockImageStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockImageStoreMockRecorder is the mock recorder for MockImageStore.
+type MockImageStoreMockRecorder struct {
+	mock *MockImageStore
+}
+
+// NewMockImageStore creates a new mock instance.
+func NewMockImageStore(ctrl *gomock.Controller) *MockImageStore {
+	mock := &MockImageStore{ctrl: ctrl}
+	mock.recorder = &MockImageStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImageStore) EXPECT() *MockImageStoreMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockImageStore) Delete(ctx context.Context, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockImageStoreMockRecorder) Delete(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockImageStore)(nil).Delete), ctx, name)
+}
+
+// Exists mocks base method.
+func (m *MockImageStore) Exists(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Exists", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exists indicates an expected call of Exists.
+func (mr *MockImageStoreMockRecorder) Exists(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exists", reflect.TypeOf((*MockImageStore)(nil).Exists), ctx, name)
+}
+
+// Get mocks base method.
+func (m *MockImageStore) Get(ctx context.Context, name string) (*StoredImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, name)
+	ret0, _ := ret[0].(*StoredImage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockImageStoreMockRecorder) Get(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockImageStore)(nil).Get), ctx, name)
+}
+
+// List mocks base method.
+func (m *MockImageStore) List(ctx context.Context) ([]ImageInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]ImageInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockImageStoreMockRecorder) List(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockImageStore)(nil).List), ctx)
+}
+
+// Put mocks base method.
+func (m *MockImageStore) Put(ctx context.Context, name string, r io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Put", ctx, name, r)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockImageStoreMockRecorder) Put(ctx, name, r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockImageStore)(nil).Put), ctx, name, r)
+}
+
+// URL mocks base method.
+func (m *MockImageStore) URL(name string) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "URL", name)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// URL indicates an expected call of URL.
+func (mr *MockImageStoreMockRecorder) URL(name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "URL", reflect.TypeOf((*MockImageStore)(nil).URL), name)
+}
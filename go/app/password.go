@@ -0,0 +1,93 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	errInvalidPasswordHash = errors.New("invalid password hash")
+	// errInvalidCredentials does not tell whether the email or the password is wrong.
+	errInvalidCredentials = errors.New("invalid email or password")
+)
+
+// argon2Params are the cost parameters of argon2id.
+type argon2Params struct {
+	// Memory is the memory used in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+// defaultArgon2Params follow the minimum recommended by OWASP, which takes tens of milliseconds.
+// The parameters are stored in each hash, so raising them does not break the existing hashes.
+var defaultArgon2Params = argon2Params{Memory: 19 * 1024, Time: 2, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+// hashPassword hashes the password with argon2id and a random salt. The result is a PHC string,
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>, which holds the parameters and the salt.
+func hashPassword(password string) (string, error) {
+	p := defaultArgon2Params
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword reports whether the password matches the hash made by hashPassword.
+func verifyPassword(password, hash string) (bool, error) {
+	p, salt, key, err := decodePasswordHash(hash)
+	if err != nil {
+		return false, err
+	}
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+// decodePasswordHash parses the PHC string made by hashPassword.
+func decodePasswordHash(hash string) (argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return argon2Params{}, nil, nil, errInvalidPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, fmt.Errorf("%w: unsupported version %s", errInvalidPasswordHash, parts[2])
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return argon2Params{}, nil, nil, fmt.Errorf("%w: %w", errInvalidPasswordHash, err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, fmt.Errorf("%w: %w", errInvalidPasswordHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return argon2Params{}, nil, nil, fmt.Errorf("%w: broken key", errInvalidPasswordHash)
+	}
+	p.SaltLen = len(salt)
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// dummyPasswordHash returns a hash verified on login with an unknown email, so that the response time
+// does not tell whether the email is registered.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword("dummy password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := hashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+	other, err := hashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == other {
+		t.Errorf("expected the hashes of the same password to differ by the salts")
+	}
+
+	parts := strings.Split(hash, "$")
+	cases := map[string]struct {
+		password string
+		hash     string
+		want     bool
+		wantErr  error
+	}{
+		"ok: correct password": {
+			password: "correct horse battery staple",
+			hash:     hash,
+			want:     true,
+		},
+		"ng: wrong password": {
+			password: "Correct horse battery staple",
+			hash:     hash,
+		},
+		"ng: other algorithm": {
+			password: "correct horse battery staple",
+			hash:     "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			wantErr:  errInvalidPasswordHash,
+		},
+		"ng: other version": {
+			password: "correct horse battery staple",
+			hash:     strings.Join([]string{"", "argon2id", "v=16", parts[3], parts[4], parts[5]}, "$"),
+			wantErr:  errInvalidPasswordHash,
+		},
+		"ng: broken salt": {
+			password: "correct horse battery staple",
+			hash:     strings.Join([]string{"", "argon2id", parts[2], parts[3], "!", parts[5]}, "$"),
+			wantErr:  errInvalidPasswordHash,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := verifyPassword(tt.password, tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// The outcomes of audit events.
+const (
+	// auditDenied is a request denied for the lack of the permission.
+	auditDenied = "denied"
+	// auditUnauthenticated is a request without a valid access token.
+	auditUnauthenticated = "unauthenticated"
+)
+
+// errPermissionDenied is returned when the role of the user does not have the permission for the request.
+var errPermissionDenied = errors.New("permission denied")
+
+// requirePermission allows only the requests by a user whose role has the permission,
+// and records the denied requests as audit events. It is wrapped by optionalAuthMiddleware,
+// so that the requests without a valid access token are recorded as well.
+//
+//	mux.Handle("POST /categories", optionalAuthMiddleware(requirePermission(h, PermManageCategories, roles, audit), sessions, users))
+func requirePermission(next http.Handler, perm Permission, roles RoleRepository, audit AuditRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := userFromContext(r.Context())
+		if user == nil {
+			recordDenied(r, audit, nil, perm, auditUnauthenticated)
+			writeAuthError(w, r, authErrorFromContext(r.Context()))
+			return
+		}
+
+		ok, err := roles.HasPermission(r.Context(), user.Role, perm)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("failed to check permission: %w", err))
+			return
+		}
+		if !ok {
+			recordDenied(r, audit, user, perm, auditDenied)
+			writeError(w, r, errPermissionDenied)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordDenied logs the denied request and records it as an audit event with the outcome.
+// The user is nil for an unauthenticated request.
+// A failure to record does not change the response, because the request is denied anyway.
+func recordDenied(r *http.Request, audit AuditRepository, user *User, perm Permission, outcome string) {
+	event := &AuditEvent{
+		Action:     r.Method + " " + r.URL.Path,
+		Permission: perm,
+		Outcome:    outcome,
+		RequestID:  requestIDFromContext(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+	}
+	var userID any // logged as null for an unauthenticated request
+	if user != nil {
+		event.UserID = &user.ID
+		userID = user.ID
+	}
+	slog.Warn("request denied", "action", event.Action, "outcome", outcome, "user_id", userID, "permission", perm,
+		"request_id", event.RequestID, "remote_addr", event.RemoteAddr)
+
+	// record the event even if the client has gone away
+	if err := audit.Record(context.WithoutCancel(r.Context()), event); err != nil {
+		slog.Error("failed to record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// RunSetRoleCommand runs the set-role command, which changes the role of a user, such as to make the first admin.
+// It returns the exit code.
+//
+//	set-role <email> <role>
+func RunSetRoleCommand(cfg Config, args []string, stdout io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprintln(stdout, "usage: set-role <email> <role>")
+		return 2
+	}
+	email, role := args[0], Role(args[1])
+
+	db, err := sql.Open("sqlite3", cfg.DBPath)
+	if err != nil {
+		slog.Error("failed to open DB", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	users := NewUserRepository(db)
+	user, err := users.GetByEmail(ctx, email)
+	if err != nil {
+		slog.Error("failed to get user", "email", email, "error", err)
+		return 1
+	}
+	if err := users.SetRole(ctx, user.ID, role); err != nil {
+		slog.Error("failed to set role", "email", email, "role", role, "error", err)
+		return 1
+	}
+	slog.Info("role changed", "id", user.ID, "from", user.Role, "to", role)
+	fmt.Fprintf(stdout, "changed the role of %s from %s to %s\n", user.Email, user.Role, role)
+	return 0
+}
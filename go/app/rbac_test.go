@@ -0,0 +1,261 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRequirePermission(t *testing.T) {
+	t.Parallel()
+
+	moderator := &User{ID: 3, Email: "carol@example.com", Name: "carol", Role: RoleModerator}
+
+	cases := map[string]struct {
+		user *User
+		// authErr is the error of authentication for an anonymous request.
+		authErr  error
+		injector func(rr *MockRoleRepository, a *MockAuditRepository)
+		code     int
+		// wantCode is the error code in the response.
+		wantCode string
+	}{
+		"ok: granted": {
+			user: moderator,
+			injector: func(rr *MockRoleRepository, a *MockAuditRepository) {
+				rr.EXPECT().HasPermission(gomock.Any(), RoleModerator, PermMergeCategories).Return(true, nil)
+			},
+			code: http.StatusOK,
+		},
+		"ng: denied": {
+			user: testSeller,
+			injector: func(rr *MockRoleRepository, a *MockAuditRepository) {
+				rr.EXPECT().HasPermission(gomock.Any(), RoleUser, PermMergeCategories).Return(false, nil)
+				a.EXPECT().Record(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, event *AuditEvent) error {
+					want := &AuditEvent{
+						UserID:     &testSeller.ID,
+						Action:     "POST /categories/1/merge",
+						Permission: PermMergeCategories,
+						Outcome:    auditDenied,
+						RemoteAddr: "192.0.2.1:1234",
+					}
+					if diff := cmp.Diff(want, event); diff != "" {
+						t.Errorf("unexpected audit event (-want +got):\n%s", diff)
+					}
+					return nil
+				})
+			},
+			code:     http.StatusForbidden,
+			wantCode: codeForbidden,
+		},
+		"ng: denied even if the audit event is not recorded": {
+			user: testSeller,
+			injector: func(rr *MockRoleRepository, a *MockAuditRepository) {
+				rr.EXPECT().HasPermission(gomock.Any(), RoleUser, PermMergeCategories).Return(false, nil)
+				a.EXPECT().Record(gomock.Any(), gomock.Any()).Return(errors.New("database is locked"))
+			},
+			code:     http.StatusForbidden,
+			wantCode: codeForbidden,
+		},
+		"ng: not authenticated": {
+			injector: func(rr *MockRoleRepository, a *MockAuditRepository) {
+				a.EXPECT().Record(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, event *AuditEvent) error {
+					want := &AuditEvent{
+						Action:     "POST /categories/1/merge",
+						Permission: PermMergeCategories,
+						Outcome:    auditUnauthenticated,
+						RemoteAddr: "192.0.2.1:1234",
+					}
+					if diff := cmp.Diff(want, event); diff != "" {
+						t.Errorf("unexpected audit event (-want +got):\n%s", diff)
+					}
+					return nil
+				})
+			},
+			authErr:  errUnauthenticated,
+			code:     http.StatusUnauthorized,
+			wantCode: codeUnauthorized,
+		},
+		"ng: invalid token": {
+			injector: func(rr *MockRoleRepository, a *MockAuditRepository) {
+				a.EXPECT().Record(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			authErr:  errInvalidToken,
+			code:     http.StatusUnauthorized,
+			wantCode: codeInvalidToken,
+		},
+		"ng: database error": {
+			user: moderator,
+			injector: func(rr *MockRoleRepository, a *MockAuditRepository) {
+				rr.EXPECT().HasPermission(gomock.Any(), RoleModerator, PermMergeCategories).Return(false, errors.New("database is locked"))
+			},
+			code:     http.StatusInternalServerError,
+			wantCode: codeInternalError,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockRR := NewMockRoleRepository(ctrl)
+			mockAR := NewMockAuditRepository(ctrl)
+			tt.injector(mockRR, mockAR)
+
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+			req := httptest.NewRequest("POST", "/categories/1/merge", nil)
+			if tt.user != nil {
+				req = withUser(req, tt.user)
+			}
+			if tt.authErr != nil {
+				req = req.WithContext(withAuthError(req.Context(), tt.authErr))
+			}
+			rr := httptest.NewRecorder()
+			requirePermission(next, PermMergeCategories, mockRR, mockAR).ServeHTTP(rr, req)
+
+			if tt.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if called != (tt.code == http.StatusOK) {
+				t.Errorf("expected the handler to be called: %v, got %v", tt.code == http.StatusOK, called)
+			}
+			if tt.code == http.StatusOK {
+				return
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("expected error code %q, got %q", tt.wantCode, resp.Code)
+			}
+			if tt.code == http.StatusUnauthorized && !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("expected the WWW-Authenticate header, got %q", rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRoleRepositoryE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	roleRepo := NewRoleRepository(db)
+
+	cases := []struct {
+		role Role
+		perm Permission
+		want bool
+	}{
+		{role: RoleUser, perm: PermHideItems, want: false},
+		{role: RoleModerator, perm: PermHideItems, want: true},
+		{role: RoleModerator, perm: PermMergeCategories, want: true},
+		{role: RoleModerator, perm: PermManageCategories, want: false},
+		{role: RoleModerator, perm: PermChangeAnyItem, want: false},
+		{role: RoleAdmin, perm: PermChangeAnyItem, want: true},
+		{role: RoleAdmin, perm: PermManageUsers, want: true},
+		{role: "owner", perm: PermManageUsers, want: false},
+	}
+	for _, tt := range cases {
+		got, err := roleRepo.HasPermission(ctx, tt.role, tt.perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("expected %s to have %s: %v, got %v", tt.role, tt.perm, tt.want, got)
+		}
+	}
+
+	userID := 1
+	event := &AuditEvent{UserID: &userID, Action: "DELETE /items/1", Permission: PermChangeAnyItem, Outcome: auditDenied, RequestID: "req", RemoteAddr: "192.0.2.1:1234"}
+	if err := NewAuditRepository(db).Record(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID == 0 || event.CreatedAt.IsZero() {
+		t.Errorf("expected the id and the creation time to be set, got %+v", event)
+	}
+	var action, outcome string
+	if err := db.QueryRowContext(ctx, `SELECT action, outcome FROM audit_events WHERE id = ?`, event.ID).Scan(&action, &outcome); err != nil {
+		t.Fatal(err)
+	}
+	if action != event.Action || outcome != auditDenied {
+		t.Errorf("unexpected audit event: %s, %s", action, outcome)
+	}
+}
+
+func TestRunSetRoleCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	cfg := DefaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "test.sqlite3")
+
+	db, err := sql.Open("sqlite3", cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := setupDatabase(t.Context(), db); err != nil {
+		t.Fatal(err)
+	}
+	userRepo := NewUserRepository(db)
+	alice := &User{Email: "alice@example.com", Name: "alice", PasswordHash: "hash"}
+	if err := userRepo.Create(t.Context(), alice); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{args: []string{"alice@example.com"}, code: 2, want: "usage"},
+		{args: []string{"bob@example.com", "admin"}, code: 1},
+		{args: []string{"alice@example.com", "owner"}, code: 1},
+		{args: []string{"Alice@Example.com", "admin"}, code: 0, want: "from user to admin"},
+	}
+	for _, tt := range cases {
+		var out bytes.Buffer
+		if code := RunSetRoleCommand(cfg, tt.args, &out); code != tt.code {
+			t.Errorf("set-role %v: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("set-role %v: expected output to contain %q, got %q", tt.args, tt.want, out.String())
+		}
+	}
+
+	got, err := userRepo.GetByID(t.Context(), alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Role != RoleAdmin {
+		t.Errorf("expected the role to be %s, got %s", RoleAdmin, got.Role)
+	}
+}
@@ -1,54 +1,54 @@
 package app
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"mercari-build-training/db/schema"
 )
 
 type Server struct {
-	// Port is the port number to listen on.
-	Port string
-	// ImageDirPath is the path to the directory storing images.
-	ImageDirPath string
-	DB           *sql.DB
+	Config Config
+	DB     *sql.DB
 }
 
 // Run is a method to start the server.
 // This method returns 0 if the server started successfully, and 1 otherwise.
 func (s Server) Run() int {
+	cfg := s.Config
+
 	// set up logger
-	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
-	slog.SetDefault(logger)
 	// STEP 4-6: set the log level to DEBUG
-	slog.SetLogLoggerLevel(slog.LevelInfo)
-
-	// set up CORS settings
-	frontURL, found := os.LookupEnv("FRONT_URL")
-	if !found {
-		frontURL = "http://localhost:3000"
-	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
+	slog.SetDefault(logger)
 
 	// STEP 5-1: set up the database connection
-	db, err := sql.Open("sqlite3", "./db/merucari.sqlite3")
+	db, err := sql.Open("sqlite3", cfg.DBPath)
 	if err != nil {
 		slog.Error("failed to open DB", "error", err)
 		return 1
 	}
 	defer db.Close()
 
-	// Read items.sql at runtime to create table
-	if err := setupDatabase(db, "./db/items.sql"); err != nil {
+	// apply the migrations embedded in the binary
+	if err := setupDatabase(context.Background(), db); err != nil {
 		slog.Error("failed to set up database", "error", err)
 		return 1
 	}
@@ -56,49 +56,245 @@ func (s Server) Run() int {
 	// set up handlers
 	itemRepo := NewItemRepository(db)
 	categoryRepo := NewCategoryRepository(db)
+	userRepo := NewUserRepository(db)
+	sessionRepo := NewSessionRepository(db)
+	roleRepo := NewRoleRepository(db)
+	auditRepo := NewAuditRepository(db)
+	images, err := newImageStore(cfg)
+	if err != nil {
+		slog.Error("failed to set up image store", "error", err)
+		return 1
+	}
 	h := &Handlers{
-		imgDirPath:   s.ImageDirPath,
-		itemRepo:     itemRepo,
-		categoryRepo: categoryRepo,
+		db:               db,
+		images:           images,
+		itemRepo:         itemRepo,
+		categoryRepo:     categoryRepo,
+		userRepo:         userRepo,
+		sessionRepo:      sessionRepo,
+		roleRepo:         roleRepo,
+		auditRepo:        auditRepo,
+		strictCategories: cfg.StrictCategories,
+		imageLimits:      cfg.ImageLimits,
+		accessTokenTTL:   cfg.AccessTokenTTL,
+		refreshTokenTTL:  cfg.RefreshTokenTTL,
 	}
 
 	// set up routes
+	// auth requires an access token for the routes which change data
+	auth := func(next http.HandlerFunc) http.Handler {
+		return authMiddleware(next, sessionRepo, userRepo)
+	}
+	// authorize requires the role of the user to have the permission as well,
+	// and audits the requests without a valid access token too
+	authorize := func(perm Permission, next http.HandlerFunc) http.Handler {
+		return optionalAuthMiddleware(requirePermission(next, perm, roleRepo, auditRepo), sessionRepo, userRepo)
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", h.Hello)
-	mux.HandleFunc("POST /items", h.AddItem)
+	mux.HandleFunc("GET /healthz", h.Health)
+	mux.HandleFunc("GET /readyz", h.Ready)
+	mux.HandleFunc("GET /version", h.Version)
+	mux.Handle("POST /items", auth(h.AddItem))
 	mux.HandleFunc("GET /items/{id}", h.GetItem)
+	mux.Handle("PATCH /items/{id}", auth(h.PatchItem))
+	mux.Handle("PUT /items/{id}", auth(h.PutItem))
+	mux.Handle("DELETE /items/{id}", auth(h.DeleteItem))
+	mux.Handle("POST /items/{id}/images", auth(h.AddItemImages))
+	mux.Handle("PUT /items/{id}/images/order", auth(h.ReorderItemImages))
+	mux.Handle("DELETE /items/{id}/images/{imageID}", auth(h.DeleteItemImage))
+	mux.Handle("PUT /items/{id}/hidden", authorize(PermHideItems, h.SetItemHidden))
 	mux.HandleFunc("GET /items", h.GetItems)
+	mux.HandleFunc("GET /users/{id}/items", h.GetUserItems)
+	mux.Handle("GET /me/items", auth(h.GetMyItems))
+	mux.Handle("GET /users", authorize(PermManageUsers, h.GetUsers))
+	mux.Handle("PUT /users/{id}/role", authorize(PermManageUsers, h.SetUserRole))
 	mux.HandleFunc("GET /images/{filename}", h.GetImage)
 	mux.HandleFunc("GET /search", h.Search)
+	mux.HandleFunc("GET /categories", h.GetCategories)
+	mux.HandleFunc("GET /categories/tree", h.GetCategoryTree)
+	mux.HandleFunc("GET /categories/{id}", h.GetCategory)
+	mux.Handle("POST /categories", authorize(PermManageCategories, h.AddCategory))
+	mux.Handle("PATCH /categories/{id}", authorize(PermManageCategories, h.PatchCategory))
+	mux.Handle("DELETE /categories/{id}", authorize(PermManageCategories, h.DeleteCategory))
+	mux.Handle("POST /categories/{id}/merge", authorize(PermMergeCategories, h.MergeCategory))
+	mux.HandleFunc("POST /auth/register", h.Register)
+	mux.HandleFunc("POST /auth/login", h.Login)
+	mux.HandleFunc("POST /auth/refresh", h.Refresh)
+	mux.Handle("POST /auth/logout", auth(h.Logout))
 
 	// start the server
-	slog.Info("http server started on", "port", s.Port)
-	err = http.ListenAndServe(":"+s.Port, simpleCORSMiddleware(simpleLoggerMiddleware(mux), frontURL, []string{"GET", "HEAD", "POST", "OPTIONS"}))
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      simpleCORSMiddleware(requestIDMiddleware(simpleLoggerMiddleware(mux)), cfg.CORSOrigins, []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	ln, err := net.Listen("tcp", server.Addr)
 	if err != nil {
 		slog.Error("failed to start server: ", "error", err)
 		return 1
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// restore the default behavior so that a second signal kills the process during the drain
+	context.AfterFunc(ctx, stop)
+
+	if cfg.CheckImagesOnStartup {
+		go func() {
+			result, err := CheckImages(ctx, images, false)
+			if err != nil {
+				slog.Error("failed to check images", "error", err)
+			}
+			if result != nil {
+				slog.Info("checked images", "checked", result.Checked, "quarantined", len(result.Corrupted))
+			}
+		}()
+	}
+	if cfg.ImageGCInterval > 0 {
+		gc := NewImageGC(itemRepo, images, cfg.ImageGCMinAge)
+		go runImageGC(ctx, gc, cfg.ImageGCInterval)
+	}
+
+	slog.Info("http server started on", "port", cfg.Port)
+	if err := serve(ctx, server, ln, h, cfg.ShutdownDelay, cfg.ShutdownTimeout); err != nil {
+		slog.Error("failed to serve: ", "error", err)
+		return 1
+	}
+	slog.Info("http server stopped")
+
 	return 0
 }
 
-// setupDatabase reads items.sql and creates a table.
-func setupDatabase(db *sql.DB, sqlFile string) error {
-	bytes, err := os.ReadFile(sqlFile)
+// serve serves HTTP requests on ln until ctx is done, and then shuts the server down gracefully.
+// Readiness fails from then on so that load balancers stop routing new requests during delay,
+// and in-flight requests are drained within timeout.
+func serve(ctx context.Context, server *http.Server, ln net.Listener, h *Handlers, delay, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	h.draining.Store(true)
+	slog.Info("shutting down http server", "delay", delay, "timeout", timeout)
+	time.Sleep(delay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// cut off the requests which did not finish in time
+		server.Close()
+		return fmt.Errorf("failed to drain connections: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newImageStore creates the image store selected by the configuration.
+func newImageStore(cfg Config) (ImageStore, error) {
+	switch cfg.ImageStore {
+	case "s3":
+		return NewS3ImageStore(cfg.S3, nil)
+	default:
+		return NewLocalImageStore(cfg.ImageDirPath), nil
+	}
+}
+
+// setupDatabase applies the pending migrations and sets up the search index.
+func setupDatabase(ctx context.Context, db *sql.DB) error {
+	migrations, err := LoadMigrations(schema.Migrations, "migrations")
 	if err != nil {
-		return fmt.Errorf("failed to read sql file: %w", err)
+		return err
 	}
-	if _, err := db.Exec(string(bytes)); err != nil {
-		return fmt.Errorf("failed to exec schema: %w", err)
+	applied, err := NewMigrator(db, migrations).Up(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	if applied > 0 {
+		slog.Info("database migrated", "applied", applied)
+	}
+
+	if err := setupSearchIndex(ctx, db); err != nil {
+		return fmt.Errorf("failed to set up search index: %w", err)
+	}
+	return nil
+}
+
+// searchIndexTriggers are the triggers created by schema.SearchIndex.
+var searchIndexTriggers = []string{
+	"items_fts_after_insert",
+	"items_fts_after_update",
+	"items_fts_after_delete",
+	"items_fts_after_category_update",
+}
+
+// setupSearchIndex creates the full-text search index if SQLite supports FTS5.
+// Otherwise it drops the triggers left by a build with FTS5 so that writes to items do not fail,
+// and search falls back to substring matching.
+func setupSearchIndex(ctx context.Context, db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to check FTS5 support: %w", err)
+	}
+
+	if !enabled {
+		slog.Warn("FTS5 is not available, build with -tags sqlite_fts5 to enable full-text search")
+		return dropSearchIndexTriggers(ctx, db)
+	}
+
+	if _, err := db.ExecContext(ctx, schema.SearchIndex); err != nil {
+		return fmt.Errorf("failed to exec search index schema: %w", err)
+	}
+	return nil
+}
+
+// dropSearchIndexTriggers drops the triggers keeping the search index in sync.
+func dropSearchIndexTriggers(ctx context.Context, db interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}) error {
+	for _, name := range searchIndexTriggers {
+		if _, err := db.ExecContext(ctx, `DROP TRIGGER IF EXISTS `+name); err != nil {
+			return fmt.Errorf("failed to drop trigger %s: %w", name, err)
+		}
 	}
 	return nil
 }
 
+// pinger checks the connection to the database. It is implemented by *sql.DB.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
 type Handlers struct {
-	// imgDirPath is the path to the directory storing images.
-	imgDirPath   string
+	db pinger
+	// images stores the uploaded images and their variants.
+	images       ImageStore
 	itemRepo     ItemRepository
 	categoryRepo CategoryRepository
+	userRepo     UserRepository
+	sessionRepo  SessionRepository
+	roleRepo     RoleRepository
+	// auditRepo records the denied requests.
+	auditRepo AuditRepository
+	// strictCategories rejects items with unknown categories instead of creating the categories.
+	strictCategories bool
+	// imageLimits are the limits of uploaded images.
+	imageLimits ImageLimits
+	// accessTokenTTL and refreshTokenTTL are the lifetimes of the tokens issued on login and refresh.
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	// draining is set when the server is shutting down and should not receive new requests.
+	draining atomic.Bool
 }
 
 type HelloResponse struct {
@@ -109,93 +305,267 @@ type HelloResponse struct {
 func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 	resp := HelloResponse{Message: "Hello, world!"}
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("failed to write response: ", "error", err)
+		return
+	}
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// Health reports that the process is alive. It does not check dependencies,
+// so that a temporary failure of them does not restart the process.
+func (s *Handlers) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(HealthResponse{Status: "ok"}); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	// Checks are the results of the dependency checks, such as {"db": "ok"}.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Ready reports whether the server can receive requests. It fails while the server is shutting down,
+// when the database does not respond, or when the image store is not writable.
+func (s *Handlers) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := ReadinessResponse{Status: "ok"}
+	status := http.StatusOK
+
+	if s.draining.Load() {
+		resp.Status = "shutting down"
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Error("failed to write response: ", "error", err)
+		}
 		return
 	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	resp.Checks = map[string]string{}
+	for name, check := range map[string]func(context.Context) error{
+		"db":     s.checkDB,
+		"images": s.checkImageStore,
+	} {
+		if err := check(ctx); err != nil {
+			slog.Error("readiness check failed", "check", name, "error", err)
+			resp.Checks[name] = "failed"
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+// readinessTimeout is the maximum duration of the readiness checks.
+const readinessTimeout = 2 * time.Second
+
+func (s *Handlers) checkDB(ctx context.Context) error {
+	if s.db == nil {
+		return errors.New("database is not configured")
+	}
+	return s.db.PingContext(ctx)
+}
+
+// checkImageStore checks that an image can be written to the image store.
+func (s *Handlers) checkImageStore(ctx context.Context) error {
+	const probe = ".readyz"
+	if err := s.images.Put(ctx, probe, strings.NewReader("ok")); err != nil {
+		return err
+	}
+	return s.images.Delete(ctx, probe)
+}
+
+// buildTime is the time when the binary was built, which Go does not record by itself.
+// Set it with -ldflags "-X mercari-build-training/app.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)".
+var buildTime string
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	// Modified is true if the binary was built from a working tree with uncommitted changes.
+	Modified   bool   `json:"modified,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	BuildTime  string `json:"build_time,omitempty"`
+}
+
+// newVersionResponse extracts the version of the binary from the build info.
+func newVersionResponse(info *debug.BuildInfo, ok bool) VersionResponse {
+	resp := VersionResponse{Version: "unknown", BuildTime: buildTime}
+	if !ok {
+		return resp
+	}
+	resp.GoVersion = info.GoVersion
+	if info.Main.Version != "" {
+		resp.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			resp.Revision = setting.Value
+		case "vcs.time":
+			resp.CommitTime = setting.Value
+		case "vcs.modified":
+			resp.Modified = setting.Value == "true"
+		}
+	}
+	return resp
+}
+
+// Version returns the version of the running binary.
+func (s *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newVersionResponse(debug.ReadBuildInfo())); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
 }
 
 type AddItemRequest struct {
 	Name     string `form:"name"`
 	Category string `form:"category"` // STEP 4-2: add a category field
-	Image    []byte `form:"image"`    // STEP 4-4: add an image field
+	// Price is the price in yen as given, which is parsed after validation.
+	Price       string           `form:"price"`
+	Condition   string           `form:"condition"`
+	Description string           `form:"description"` // optional
+	Brand       string           `form:"brand"`       // optional
+	Images      []*UploadedImage `form:"image"`       // STEP 4-4: add an image field, which can be repeated
 }
 
-type AddItemResponse struct {
-	Message string `json:"message"`
+// formText returns the multi-line text field of the form with the line breaks normalized to \n,
+// because browsers send them as \r\n.
+func formText(values url.Values, key string) string {
+	return strings.ReplaceAll(values.Get(key), "\r\n", "\n")
 }
 
 // parseAddItemRequest parses and validates the request to add an item.
-func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
-	req := &AddItemRequest{
-		Name:     r.FormValue("name"),
-		Category: r.FormValue("category"), // STEP 4-2: add a category field
-	}
-
-	// STEP 4-4: add an image field
-	uploadFile, _, err := r.FormFile("image")
+// The images are streamed to temporary files by up, and the caller must close them.
+func parseAddItemRequest(r *http.Request, up imageUploader) (_ *AddItemRequest, e error) {
+	values, images, err := up.readForm(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image file: %w", err)
+		return nil, err
 	}
 	defer func() {
-		if cerr := uploadFile.Close(); cerr != nil {
-			slog.Warn("failed to close image file", "error", cerr)
+		if e != nil {
+			closeUploads(images)
 		}
 	}()
-
-	imageData, err := io.ReadAll(uploadFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image file: %w", err)
+	req := &AddItemRequest{
+		Name:        values.Get("name"),
+		Category:    values.Get("category"), // STEP 4-2: add a category field
+		Price:       values.Get("price"),
+		Condition:   values.Get("condition"),
+		Description: formText(values, "description"),
+		Brand:       values.Get("brand"),
+		Images:      images, // STEP 4-4: add an image field
 	}
-	req.Image = imageData
 
 	// validate the request
-	if req.Name == "" {
-		return nil, errors.New("name is required")
+	if err := req.validate(up.limits); err != nil {
+		return nil, err
 	}
+	return req, nil
+}
 
-	// STEP 4-2: validate the category field
-	if req.Category == "" {
-		return nil, errors.New("category is required")
+// validate checks all the fields of the request, and returns all the violations together.
+func (req *AddItemRequest) validate(limits ImageLimits) error {
+	v := &validator{}
+	checkField(v, "name", req.Name, itemNameRules...)
+	checkField(v, "category", req.Category, categoryNameRules...) // STEP 4-2: validate the category field
+	checkField(v, "price", req.Price, priceRules...)
+	checkField(v, "condition", req.Condition, conditionRules...)
+	checkField(v, "description", req.Description, descriptionRules...)
+	checkField(v, "brand", req.Brand, brandRules...)
+	checkField(v, "image", req.Images, itemImagesRules...) // STEP 4-4: validate the image field
+	checkEach(v, "image", req.Images, validImage(limits))
+	if err := supportedImages(req.Images); err != nil {
+		return err
 	}
+	return v.err()
+}
 
-	// STEP 4-4: validate the image field
-	if len(req.Image) == 0 {
-		return nil, errors.New("image is required")
+// storeImages stores the images and returns their file names.
+// It responds with an error and returns false if any of them cannot be stored.
+func (s *Handlers) storeImages(w http.ResponseWriter, r *http.Request, images []*UploadedImage) ([]string, bool) {
+	names := make([]string, 0, len(images))
+	for _, image := range images {
+		fileName, err := s.storeImage(r.Context(), image)
+		if err != nil {
+			writeError(w, r, err)
+			return nil, false
+		}
+		names = append(names, fileName)
 	}
-	return req, nil
+	return names, true
 }
 
 // AddItem is a handler to add a new item for POST /items .
 func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	req, err := parseAddItemRequest(r)
+	// the authenticated user becomes the seller
+	seller := userFromContext(ctx)
+	if seller == nil {
+		writeError(w, r, errUnauthenticated)
+		return
+	}
+
+	up, err := s.uploader()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.limitRequestBody(w, r, maxItemImages)
+	req, err := parseAddItemRequest(r, up)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, invalidRequest(err))
 		return
 	}
+	defer closeUploads(req.Images)
 
 	// STEP 4-4: uncomment on adding an implementation to store an image
-	fileName, err := s.storeImage(req.Image)
-	if err != nil {
-		slog.Error("failed to store image: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	fileNames, ok := s.storeImages(w, r, req.Images)
+	if !ok {
 		return
 	}
 
 	// Get or create a category ID
-	categoryID, err := s.categoryRepo.GetOrCreate(ctx, req.Category)
+	categoryID, err := s.resolveCategory(ctx, req.Category)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get or create category: %w", err))
+		return
+	}
+	price, err := parsePrice(req.Price)
 	if err != nil {
-		slog.Error("failed to get or create category: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, invalidRequest(err))
 		return
 	}
+	condition := Condition(req.Condition)
 
 	item := &Item{
-		Name:       req.Name,
-		Category:   req.Category,
-		CategoryID: categoryID,
-		ImageName:  fileName, // STEP 4-4: add an image field
+		Name:        req.Name,
+		Category:    req.Category,
+		CategoryID:  categoryID,
+		SellerID:    &seller.ID,
+		Price:       &price,
+		Condition:   &condition,
+		Description: req.Description,
+		Brand:       req.Brand,
+		Images:      make([]*ItemImage, 0, len(fileNames)), // STEP 4-4: add an image field
+	}
+	for _, fileName := range fileNames {
+		item.Images = append(item.Images, &ItemImage{ImageName: fileName})
 	}
 	message := fmt.Sprintf("item received: %s", item.Name)
 	slog.Info(message)
@@ -203,203 +573,1542 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 	// STEP 4-2: add an implementation to store an item
 	err = s.itemRepo.Insert(ctx, item)
 	if err != nil {
-		slog.Error("failed to store item: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("failed to store item: %w", err))
 		return
 	}
 
-	resp := AddItemResponse{Message: message}
 	message = fmt.Sprintf("item stored: %s", item.Name)
 	slog.Info(message)
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	// return the created item with its location
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/items/%d", item.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newItemV1(item, s.imageURL(r))); err != nil {
+		slog.Error("failed to encode item: ", "error", err)
 		return
 	}
 }
 
-// GetItem is a handler to return items for GET /items/{id}
-func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// resolveCategory returns the id of the category with the name.
+// It creates the category if it does not exist, or rejects the unknown category in strict mode.
+func (s *Handlers) resolveCategory(ctx context.Context, name string) (int, error) {
+	if !s.strictCategories {
+		return s.categoryRepo.GetOrCreate(ctx, name)
+	}
+	category, err := s.categoryRepo.GetByName(ctx, name)
+	if errors.Is(err, errCategoryNotFound) {
+		return 0, &APIError{Status: http.StatusBadRequest, Code: codeUnknownCategory, Message: fmt.Sprintf("unknown category: %s", name)}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return category.ID, nil
+}
 
-	// Get path parameter id
-	sid := r.PathValue("id")
-	if sid == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
-		return
+// ItemV1 is the version 1 representation of an item in responses.
+// Fields may be added to it, but renaming or removing a field requires a new version.
+type ItemV1 struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Category   string `json:"category"`
+	CategoryID int    `json:"category_id"`
+	// SellerID is the id of the user who listed the item. It is null for the items added before accounts.
+	SellerID *int `json:"seller_id"`
+	// Price is the price in yen, and Condition is one of new, like_new, good, fair and poor.
+	// They are null for the items listed before prices.
+	Price       *int       `json:"price"`
+	Condition   *Condition `json:"condition"`
+	Description string     `json:"description"`
+	// Brand is empty for an item without a brand.
+	Brand string `json:"brand"`
+	// Hidden reports whether a moderator hid the item from lists and searches.
+	Hidden bool `json:"hidden"`
+	// ImageURL is the URL of the cover image.
+	ImageURL string `json:"image_url"`
+	// Images are all the images of the item in display order.
+	Images    []*ItemImageV1 `json:"images"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ItemImageV1 is the version 1 representation of an image of an item in responses.
+type ItemImageV1 struct {
+	ID       int    `json:"id"`
+	URL      string `json:"url"`
+	Position int    `json:"position"`
+	IsCover  bool   `json:"is_cover"`
+}
+
+// newItemV1 converts a stored item into its representation.
+// imageURL builds the URL of the image, which is usually Handlers.imageURL .
+func newItemV1(item *Item, imageURL func(imageName string) string) *ItemV1 {
+	images := make([]*ItemImageV1, 0, len(item.Images))
+	for _, img := range item.Images {
+		images = append(images, &ItemImageV1{
+			ID:       img.ID,
+			URL:      imageURL(img.ImageName),
+			Position: img.Position,
+			IsCover:  img.IsCover,
+		})
 	}
+	return &ItemV1{
+		ID:          item.ID,
+		Name:        item.Name,
+		Category:    item.Category,
+		CategoryID:  item.CategoryID,
+		SellerID:    item.SellerID,
+		Price:       item.Price,
+		Condition:   item.Condition,
+		Description: item.Description,
+		Brand:       item.Brand,
+		Hidden:      item.HiddenAt != nil,
+		ImageURL:    imageURL(item.ImageName),
+		Images:      images,
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
+	}
+}
 
-	// Convert id to integer
-	id, err := strconv.Atoi(sid)
-	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
-		return
+func newItemsV1(items []*Item, imageURL func(imageName string) string) []*ItemV1 {
+	res := make([]*ItemV1, 0, len(items))
+	for _, item := range items {
+		res = append(res, newItemV1(item, imageURL))
 	}
+	return res
+}
 
-	// Get item by the id
-	item, err := s.itemRepo.Select(ctx, id)
-	if err != nil {
-		if errors.Is(err, errItemNotFound) {
-			http.Error(w, "item not found", http.StatusNotFound)
-			return
+// imageURL returns a function building the absolute URL of an image for the request.
+// Images are served by GetImage unless the image store serves them directly.
+// Items without an image point to the default image.
+func (s *Handlers) imageURL(r *http.Request) func(imageName string) string {
+	base := baseURL(r)
+	return func(imageName string) string {
+		if imageName == "" {
+			return base + imageURLPath(defaultImageName)
 		}
-		slog.Error("failed to get item: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		// image names were stored as paths under the image directory in older versions
+		u := s.images.URL(filepath.Base(imageName))
+		if strings.HasPrefix(u, "/") {
+			return base + u
+		}
+		return u
 	}
+}
 
-	// Return the item
-	if err := json.NewEncoder(w).Encode(item); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// baseURL returns the scheme and host the client used to reach the server.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
 	}
+	return scheme + "://" + r.Host
 }
 
-type GetItemsResponse struct {
-	Items []*Item `json:"items"`
+// parsePathID parses and validates the path parameter id.
+func parsePathID(r *http.Request) (int, error) {
+	sid := r.PathValue("id")
+	if sid == "" {
+		return 0, errors.New("id is required")
+	}
+	id, err := strconv.Atoi(sid)
+	if err != nil {
+		return 0, errors.New("id must be an integer")
+	}
+	return id, nil
 }
 
-// GetItems is a handler to return items for GET /items .
-func (s *Handlers) GetItems(w http.ResponseWriter, r *http.Request) {
+// GetItem is a handler to return items for GET /items/{id}
+func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	items, err := s.itemRepo.List(ctx)
+	// Get path parameter id
+	id, err := parsePathID(r)
 	if err != nil {
-		slog.Error("failed to get items: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, invalidRequest(err))
 		return
 	}
 
-	resp := GetItemsResponse{Items: items}
-	err = json.NewEncoder(w).Encode(resp)
+	// Get item by the id
+	item, err := s.itemRepo.Select(ctx, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("failed to get item: %w", err))
 		return
 	}
+	// a hidden item is only listed for its seller by GET /me/items
+	if item.HiddenAt != nil {
+		writeError(w, r, errItemNotFound)
+		return
+	}
+
+	// Return the item
+	if err := json.NewEncoder(w).Encode(newItemV1(item, s.imageURL(r))); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
 }
 
-// storeImage stores an image and returns the file path and an error if any.
-// this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
-// and stores it in the image directory.
-func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
-	// STEP 4-4: add an implementation to store an image
-	// TODO:
-	// - calc hash sum
-	hash := sha256.Sum256(image)
-	hashStr := hex.EncodeToString(hash[:])
+// maxFormMemory is the maximum bytes of a multipart form stored in memory.
+const maxFormMemory = 32 << 20
 
-	// - build image file path
-	filePath = filepath.Join(s.imgDirPath, fmt.Sprintf("%s.jpg", hashStr))
+type UpdateItemRequest struct {
+	ID          int              // path value
+	Name        *string          `form:"name"`        // nil if not specified
+	Category    *string          `form:"category"`    // nil if not specified
+	Price       *string          `form:"price"`       // nil if not specified
+	Condition   *string          `form:"condition"`   // nil if not specified
+	Description *string          `form:"description"` // nil if not specified
+	Brand       *string          `form:"brand"`       // nil if not specified
+	Images      []*UploadedImage `form:"image"`       // nil if not specified
+}
 
-	// - check if the image already exists
-	if _, err := os.Stat(filePath); err == nil {
-		return filePath, nil
-	} else if !os.IsNotExist(err) {
-		return "", fmt.Errorf("error checking image existence: %w", err)
+// parseUpdateItemRequest parses and validates the request to update an item.
+// If partial is false, all the fields are required as in adding an item.
+// The images are streamed to temporary files by up, and the caller must close them.
+func parseUpdateItemRequest(r *http.Request, up imageUploader, partial bool) (_ *UpdateItemRequest, e error) {
+	id, err := parsePathID(r)
+	if err != nil {
+		return nil, err
 	}
+	req := &UpdateItemRequest{ID: id}
 
-	// - store image
-	if err := StoreImage(filePath, image); err != nil {
-		return "", fmt.Errorf("fail to store image: %w", err)
+	values, images, err := up.readForm(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e != nil {
+			closeUploads(images)
+		}
+	}()
+	if values.Has("name") {
+		name := values.Get("name")
+		req.Name = &name
+	}
+	if values.Has("category") {
+		category := values.Get("category")
+		req.Category = &category
+	}
+	if values.Has("price") {
+		price := values.Get("price")
+		req.Price = &price
+	}
+	if values.Has("condition") {
+		condition := values.Get("condition")
+		req.Condition = &condition
+	}
+	if values.Has("description") {
+		description := formText(values, "description")
+		req.Description = &description
+	}
+	if values.Has("brand") {
+		brand := values.Get("brand")
+		req.Brand = &brand
 	}
 
-	// - return the image file path
-	return filePath, nil
-}
+	// the images replace all the images of the item
+	req.Images = images
 
-type GetImageRequest struct {
-	FileName string // path value
+	// validate the request
+	if err := req.validate(up.limits, partial); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
-// parseGetImageRequest parses and validates the request to get an image.
-func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
-	req := &GetImageRequest{
-		FileName: r.PathValue("filename"), // from path parameter
+// validate checks the fields of the request with the same rules as adding an item.
+// If partial is true, only the specified fields are checked.
+func (req *UpdateItemRequest) validate(limits ImageLimits, partial bool) error {
+	v := &validator{}
+	if partial && req.Name == nil && req.Category == nil && req.Price == nil && req.Condition == nil &&
+		req.Description == nil && req.Brand == nil && req.Images == nil {
+		v.add("", fieldRequired, "at least one of name, category, price, condition, description, brand and image is required")
+		return v.err()
 	}
-
-	// validate the request
-	if req.FileName == "" {
-		return nil, errors.New("filename is required")
+	if req.Name != nil || !partial {
+		checkField(v, "name", valueOrZero(req.Name), itemNameRules...)
+	}
+	if req.Category != nil || !partial {
+		checkField(v, "category", valueOrZero(req.Category), categoryNameRules...)
+	}
+	if req.Price != nil || !partial {
+		checkField(v, "price", valueOrZero(req.Price), priceRules...)
+	}
+	if req.Condition != nil || !partial {
+		checkField(v, "condition", valueOrZero(req.Condition), conditionRules...)
+	}
+	// the optional fields are cleared by a full update without them
+	if req.Description != nil || !partial {
+		checkField(v, "description", valueOrZero(req.Description), descriptionRules...)
 	}
+	if req.Brand != nil || !partial {
+		checkField(v, "brand", valueOrZero(req.Brand), brandRules...)
+	}
+	if req.Images != nil || !partial {
+		checkField(v, "image", req.Images, itemImagesRules...)
+		checkEach(v, "image", req.Images, validImage(limits))
+	}
+	if err := supportedImages(req.Images); err != nil {
+		return err
+	}
+	return v.err()
+}
 
-	return req, nil
+// PatchItem is a handler to partially update an item for PATCH /items/{id} .
+// Only the specified fields among name, category, price, condition, description, brand and image are updated.
+func (s *Handlers) PatchItem(w http.ResponseWriter, r *http.Request) {
+	s.updateItem(w, r, true)
 }
 
-// GetImage is a handler to return an image for GET /images/{filename} .
-// If the specified image is not found, it returns the default image.
-func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
-	req, err := parseGetImageRequest(r)
+// PutItem is a handler to replace an item for PUT /items/{id} .
+func (s *Handlers) PutItem(w http.ResponseWriter, r *http.Request) {
+	s.updateItem(w, r, false)
+}
+
+func (s *Handlers) updateItem(w http.ResponseWriter, r *http.Request, partial bool) {
+	ctx := r.Context()
+
+	up, err := s.uploader()
 	if err != nil {
-		slog.Warn("failed to parse get image request: ", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	s.limitRequestBody(w, r, maxItemImages)
+	req, err := parseUpdateItemRequest(r, up, partial)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
 		return
 	}
+	defer closeUploads(req.Images)
 
-	imgPath, err := s.buildImagePath(req.FileName)
+	item, err := s.authorizeItem(r, req.ID)
 	if err != nil {
-		if !errors.Is(err, errImageNotFound) {
-			slog.Warn("failed to build image path: ", "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+
+	if req.Name != nil {
+		item.Name = *req.Name
+	}
+	if req.Category != nil && *req.Category != item.Category {
+		categoryID, err := s.resolveCategory(ctx, *req.Category)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("failed to get or create category: %w", err))
+			return
+		}
+		item.Category = *req.Category
+		item.CategoryID = categoryID
+	}
+	if req.Price != nil {
+		price, err := parsePrice(*req.Price)
+		if err != nil {
+			writeError(w, r, invalidRequest(err))
+			return
+		}
+		item.Price = &price
+	}
+	if req.Condition != nil {
+		condition := Condition(*req.Condition)
+		item.Condition = &condition
+	}
+	if !partial || req.Description != nil {
+		item.Description = valueOrZero(req.Description)
+	}
+	if !partial || req.Brand != nil {
+		item.Brand = valueOrZero(req.Brand)
+	}
+	var fileNames []string
+	if req.Images != nil {
+		var ok bool
+		if fileNames, ok = s.storeImages(w, r, req.Images); !ok {
 			return
 		}
+	}
 
-		// when the image is not found, it returns the default image without an error.
-		slog.Debug("image not found", "filename", imgPath)
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+	// the fields and the images are updated together, or neither is
+	if err := s.itemRepo.Update(ctx, item, fileNames); err != nil {
+		writeError(w, r, fmt.Errorf("failed to update item: %w", err))
+		return
+	}
+	slog.Info("item updated", "id", item.ID)
+
+	if fileNames != nil {
+		// reload the item for the new images
+		s.writeItem(w, r, item.ID, http.StatusOK)
+		return
 	}
 
-	slog.Info("returned image", "path", imgPath)
-	http.ServeFile(w, r, imgPath)
+	if err := json.NewEncoder(w).Encode(newItemV1(item, s.imageURL(r))); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
 }
 
-// buildImagePath builds the image path and validates it.
-func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
-	imgPath := filepath.Join(s.imgDirPath, filepath.Clean(imageFileName))
+// errNotItemSeller is returned when a user other than the seller tries to change an item.
+var errNotItemSeller = errors.New("only the seller can change the item")
 
-	// to prevent directory traversal attacks
-	rel, err := filepath.Rel(s.imgDirPath, imgPath)
-	if err != nil || strings.HasPrefix(rel, "..") {
-		return "", fmt.Errorf("invalid image path: %s", imgPath)
+// authorizeItem returns the item if the authenticated user can change it, which is the seller
+// or a user with PermChangeAnyItem. The denied requests are recorded as audit events.
+func (s *Handlers) authorizeItem(r *http.Request, id int) (*Item, error) {
+	ctx := r.Context()
+	user := userFromContext(ctx)
+	if user == nil {
+		return nil, errUnauthenticated
 	}
-
-	// validate the image suffix
-	if !strings.HasSuffix(imgPath, ".jpg") && !strings.HasSuffix(imgPath, ".jpeg") {
-		return "", fmt.Errorf("image path does not end with .jpg or .jpeg: %s", imgPath)
+	item, err := s.itemRepo.Select(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
 	}
-
-	// check if the image exists
-	_, err = os.Stat(imgPath)
+	if item.SellerID != nil && *item.SellerID == user.ID {
+		return item, nil
+	}
+	ok, err := s.roleRepo.HasPermission(ctx, user.Role, PermChangeAnyItem)
 	if err != nil {
-		return imgPath, errImageNotFound
+		return nil, fmt.Errorf("failed to check permission: %w", err)
 	}
-
-	return imgPath, nil
+	if !ok {
+		recordDenied(r, s.auditRepo, user, PermChangeAnyItem, auditDenied)
+		return nil, errNotItemSeller
+	}
+	return item, nil
 }
 
-// Search is a handler to return items that match the keyword for GET /search
-func (s *Handlers) Search(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	// Get keywords from query parameters
-	keyword := r.URL.Query().Get("keyword")
-	if keyword == "" {
-		http.Error(w, "keyword parameter is required", http.StatusBadRequest)
+// writeItem responds with the current state of the item.
+func (s *Handlers) writeItem(w http.ResponseWriter, r *http.Request, id int, status int) {
+	item, err := s.itemRepo.Select(r.Context(), id)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get item: %w", err))
 		return
 	}
 
-	// Search items by keywords
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(newItemV1(item, s.imageURL(r))); err != nil {
+		slog.Error("failed to encode item: ", "error", err)
+	}
+}
+
+// AddItemImages is a handler to append the repeated image parts to an item for POST /items/{id}/images .
+func (s *Handlers) AddItemImages(w http.ResponseWriter, r *http.Request) {
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	// check the seller before reading the images
+	if _, err := s.authorizeItem(r, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	up, err := s.uploader()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.limitRequestBody(w, r, maxItemImages)
+	_, images, err := up.readForm(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	defer closeUploads(images)
+	v := &validator{}
+	checkField(v, "image", images, itemImagesRules...)
+	checkEach(v, "image", images, validImage(up.limits))
+	if err := supportedImages(images); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := v.err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	fileNames, ok := s.storeImages(w, r, images)
+	if !ok {
+		return
+	}
+	if err := s.itemRepo.AddImages(r.Context(), id, fileNames); err != nil {
+		writeError(w, r, fmt.Errorf("failed to change item images: %w", err))
+		return
+	}
+	slog.Info("item images added", "id", id, "count", len(fileNames))
+
+	s.writeItem(w, r, id, http.StatusCreated)
+}
+
+// DeleteItemImage is a handler to remove an image from an item for DELETE /items/{id}/images/{imageID} .
+func (s *Handlers) DeleteItemImage(w http.ResponseWriter, r *http.Request) {
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	imageID, err := strconv.Atoi(r.PathValue("imageID"))
+	if err != nil || imageID <= 0 {
+		writeError(w, r, invalidRequest(errors.New("image id must be a positive integer")))
+		return
+	}
+	if _, err := s.authorizeItem(r, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.itemRepo.DeleteImage(r.Context(), id, imageID); err != nil {
+		writeError(w, r, fmt.Errorf("failed to change item images: %w", err))
+		return
+	}
+	slog.Info("item image deleted", "id", id, "image_id", imageID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ReorderItemImagesRequest struct {
+	ID int `json:"-"` // path value
+	// ImageIDs are the ids of all the images of the item in the new order.
+	ImageIDs []int `json:"image_ids"`
+	// CoverImageID is the id of the new cover image. 0 keeps the current cover.
+	CoverImageID int `json:"cover_image_id"`
+}
+
+// ReorderItemImages is a handler to reorder the images of an item for PUT /items/{id}/images/order .
+func (s *Handlers) ReorderItemImages(w http.ResponseWriter, r *http.Request) {
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	req := &ReorderItemImagesRequest{ID: id}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	if _, err := s.authorizeItem(r, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.itemRepo.ReorderImages(r.Context(), req.ID, req.ImageIDs, req.CoverImageID); err != nil {
+		writeError(w, r, fmt.Errorf("failed to change item images: %w", err))
+		return
+	}
+	slog.Info("item images reordered", "id", id)
+
+	s.writeItem(w, r, id, http.StatusOK)
+}
+
+// DeleteItem is a handler to delete an item for DELETE /items/{id} .
+func (s *Handlers) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	if _, err := s.authorizeItem(r, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.itemRepo.Delete(ctx, id); err != nil {
+		writeError(w, r, fmt.Errorf("failed to delete item: %w", err))
+		return
+	}
+	slog.Info("item deleted", "id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type SetItemHiddenRequest struct {
+	Hidden bool `json:"hidden"`
+}
+
+// SetItemHidden is a handler to hide an item from lists and searches, or show it again, for PUT /items/{id}/hidden .
+func (s *Handlers) SetItemHidden(w http.ResponseWriter, r *http.Request) {
+	me := userFromContext(r.Context())
+	if me == nil {
+		writeError(w, r, errUnauthenticated)
+		return
+	}
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	req := &SetItemHiddenRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	if err := s.itemRepo.SetHidden(r.Context(), id, req.Hidden); err != nil {
+		writeError(w, r, fmt.Errorf("failed to change item visibility: %w", err))
+		return
+	}
+	slog.Info("item visibility changed", "id", id, "hidden", req.Hidden, "by", me.ID)
+
+	s.writeItem(w, r, id, http.StatusOK)
+}
+
+type GetItemsResponse struct {
+	Items      []*ItemV1 `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+const (
+	defaultItemsLimit = 20
+	maxItemsLimit     = 100
+)
+
+type GetItemsRequest struct {
+	Limit      int      // query parameter
+	Cursor     string   // query parameter
+	Sort       ItemSort // query parameter
+	CategoryID int      // query parameter, zero if not specified
+}
+
+// parseGetItemsRequest parses and validates the request to get items.
+func parseGetItemsRequest(r *http.Request) (*GetItemsRequest, error) {
+	q := r.URL.Query()
+	req := &GetItemsRequest{
+		Limit:  defaultItemsLimit,
+		Cursor: q.Get("cursor"),
+		Sort:   ItemSort(q.Get("sort")),
+	}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("limit must be an integer")
+		}
+		if limit < 1 || limit > maxItemsLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxItemsLimit)
+		}
+		req.Limit = limit
+	}
+
+	if s := q.Get("category_id"); s != "" {
+		categoryID, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("category_id must be an integer")
+		}
+		req.CategoryID = categoryID
+	}
+
+	// validate the request
+	switch req.Sort {
+	case "":
+		req.Sort = ItemSortNewest
+	case ItemSortNewest, ItemSortName, ItemSortPrice:
+	default:
+		return nil, fmt.Errorf("unknown sort: %s", req.Sort)
+	}
+
+	return req, nil
+}
+
+// GetItems is a handler to return a page of items for GET /items .
+func (s *Handlers) GetItems(w http.ResponseWriter, r *http.Request) {
+	s.listItems(w, r, 0, false)
+}
+
+// GetUserItems is a handler to return a page of the items listed by a user for GET /users/{id}/items .
+func (s *Handlers) GetUserItems(w http.ResponseWriter, r *http.Request) {
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	if _, err := s.userRepo.GetByID(r.Context(), id); err != nil {
+		writeError(w, r, fmt.Errorf("failed to get user: %w", err))
+		return
+	}
+	s.listItems(w, r, id, false)
+}
+
+// GetMyItems is a handler to return a page of the items listed by the authenticated user for GET /me/items .
+// It includes the hidden items, so that the user knows which ones are hidden.
+func (s *Handlers) GetMyItems(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeError(w, r, errUnauthenticated)
+		return
+	}
+	s.listItems(w, r, user.ID, true)
+}
+
+// listItems responds with a page of items, which are limited to the ones listed by the seller if sellerID is not zero.
+// The hidden items are listed only if includeHidden is true.
+func (s *Handlers) listItems(w http.ResponseWriter, r *http.Request, sellerID int, includeHidden bool) {
+	ctx := r.Context()
+
+	req, err := parseGetItemsRequest(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	if req.CategoryID != 0 {
+		if _, err := s.categoryRepo.GetByID(ctx, req.CategoryID); err != nil {
+			writeError(w, r, fmt.Errorf("failed to get category: %w", err))
+			return
+		}
+	}
+
+	page, err := s.itemRepo.ListPage(ctx, ListItemsParams{
+		Limit:      req.Limit,
+		Cursor:     req.Cursor,
+		Sort:       req.Sort,
+		CategoryID: req.CategoryID,
+		SellerID:   sellerID,
+
+		IncludeHidden: includeHidden,
+	})
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get items: %w", err))
+		return
+	}
+
+	resp := GetItemsResponse{Items: newItemsV1(page.Items, s.imageURL(r)), NextCursor: page.NextCursor}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		slog.Error("failed to write response: ", "error", err)
+		return
+	}
+}
+
+// storeImage stores an image and returns the file name and an error if any.
+// this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
+// and stores it in the image directory.
+func (s *Handlers) storeImage(ctx context.Context, image *UploadedImage) (fileName string, err error) {
+	// STEP 4-4: add an implementation to store an image
+	// TODO:
+	// - validate the image and detect the format
+	f, err := image.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open uploaded image: %w", err)
+	}
+	// only the header is decoded here, since a whole image within the limits can take hundreds of
+	// megabytes of memory and a request can carry several of them
+	format, err := detectImageFormat(f, image.Size, s.imageLimits)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// - calc hash sum, which is done while uploading
+	// - build image file name
+	fileName = image.Hash + format.Ext
+
+	// - store image, even if it already exists, to renew its modification time
+	//   so that the garbage collection does not delete it before the item is saved
+	if err := putUploadedImage(ctx, s.images, fileName, image); err != nil {
+		return "", fmt.Errorf("fail to store image: %w", err)
+	}
+
+	// - verify the stored image, so that a broken write is never referred to by items
+	if err := verifyImage(ctx, s.images, fileName); err != nil {
+		if errors.Is(err, errImageCorrupted) {
+			if err := s.images.Delete(ctx, fileName); err != nil {
+				slog.Warn("failed to delete corrupted image", "filename", fileName, "error", err)
+			}
+		}
+		return "", err
+	}
+
+	// - the resized variants are created on the first request for them by imageVariant
+
+	// - return the image file name
+	return fileName, nil
+}
+
+type GetImageRequest struct {
+	FileName string // path value
+	// Width is the requested width of the image. 0 means the original size.
+	Width int // query parameter w
+}
+
+// parseGetImageRequest parses and validates the request to get an image.
+func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
+	req := &GetImageRequest{
+		FileName: r.PathValue("filename"), // from path parameter
+	}
+
+	// validate the request
+	if req.FileName == "" {
+		return nil, errors.New("filename is required")
+	}
+	if w := r.URL.Query().Get("w"); w != "" {
+		width, err := strconv.Atoi(w)
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("w must be a positive integer: %q", w)
+		}
+		req.Width = width
+	}
+
+	return req, nil
+}
+
+// GetImage is a handler to return an image for GET /images/{filename} .
+// If the specified image is not found, it returns the default image.
+// With ?w=<width>, it returns the nearest resized variant instead of the original.
+func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseGetImageRequest(r)
+	if err != nil {
+		slog.Warn("failed to parse get image request: ", "error", err)
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	name, err := s.resolveImageName(ctx, req.FileName)
+	fallback := false
+	if err != nil {
+		if !errors.Is(err, errImageNotFound) {
+			slog.Warn("failed to resolve image name: ", "error", err)
+			writeError(w, r, err)
+			return
+		}
+
+		// when the image is not found, it returns the default image without an error.
+		slog.Debug("image not found", "filename", req.FileName)
+		name = defaultImageName
+		fallback = true
+	}
+
+	original, width := name, 0
+	if req.Width > 0 {
+		variant, err := imageVariant(ctx, s.images, name, req.Width)
+		if err != nil {
+			// the original image is still usable
+			slog.Warn("failed to get image variant", "filename", name, "width", req.Width, "error", err)
+		} else if variant != name {
+			name, width = variant, nearestVariantWidth(req.Width)
+		}
+	}
+
+	if fallback {
+		// the image may be uploaded later, so the fallback must not be cached for the requested name
+		w.Header().Set(imageFallbackHeader, "default")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else if etag := imageETag(original, width); etag != "" {
+		// the content of a hashed name never changes, so the cached image is always valid
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", immutableCacheControl)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else {
+		// images stored by old versions are revalidated with Last-Modified
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	img, err := s.images.Get(ctx, name)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get image: %w", err))
+		return
+	}
+	defer img.Close()
+
+	slog.Info("returned image", "filename", name)
+	http.ServeContent(w, r, name, img.ModTime, img)
+}
+
+// defaultImageName is the image returned for items without an image or missing images.
+const defaultImageName = "default.jpg"
+
+// imageFallbackHeader tells clients that the requested image is not found and the default image is returned.
+const imageFallbackHeader = "X-Image-Fallback"
+
+// immutableCacheControl lets clients cache the images with hashed names for a year without revalidation.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// imageETag returns the strong ETag of the original image or its variant of width, derived from the hash
+// in the name of the original. width is 0 for the original. It returns "" for the names without a hash.
+func imageETag(original string, width int) string {
+	hash, ok := imageNameHash(original)
+	if !ok {
+		return ""
+	}
+	if width > 0 {
+		return fmt.Sprintf(`"%s-w%d"`, hash, width)
+	}
+	return `"` + hash + `"`
+}
+
+// etagMatches reports whether the If-None-Match header matches etag with the weak comparison of RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveImageName validates the requested image file name, and returns errImageNotFound
+// if the image is not stored.
+func (s *Handlers) resolveImageName(ctx context.Context, imageFileName string) (string, error) {
+	// to prevent directory traversal attacks and access to variants
+	if err := validateImageName(imageFileName); err != nil || strings.Contains(imageFileName, "/") {
+		return "", invalidRequest(fmt.Errorf("invalid image name: %s", imageFileName))
+	}
+
+	// validate the image suffix
+	if !isImageExt(path.Ext(imageFileName)) {
+		return "", invalidRequest(fmt.Errorf("image name does not end with an image extension: %s", imageFileName))
+	}
+
+	// check if the image exists
+	ok, err := s.images.Exists(ctx, imageFileName)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return imageFileName, errImageNotFound
+	}
+
+	return imageFileName, nil
+}
+
+// SearchResultV1 is the version 1 representation of a search result in responses.
+type SearchResultV1 struct {
+	ItemV1
+	// Snippet is an HTML-escaped excerpt of the matched text with the matches wrapped in <mark> tags.
+	Snippet string `json:"snippet"`
+	// Score is the relevance of the item. A higher score is more relevant.
+	Score float64 `json:"score"`
+}
+
+type SearchResponse struct {
+	Items []*SearchResultV1 `json:"items"`
+}
+
+// Search is a handler to return items that match the keyword for GET /search .
+// The keyword may contain multiple words, "quoted phrases" and prefixes such as jack*.
+func (s *Handlers) Search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Get keywords from query parameters
+	keyword := r.URL.Query().Get("keyword")
+	if keyword == "" {
+		writeError(w, r, invalidRequest(errors.New("keyword parameter is required")))
+		return
+	}
+
+	// Search items by keywords
 	items, err := s.itemRepo.SearchByKeyword(ctx, keyword)
 	if err != nil {
-		slog.Error("failed to search items", "error", err, "keyword", keyword)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("failed to search items for %q: %w", keyword, err))
 		return
 	}
 
 	// Return search result
-	resp := GetItemsResponse{Items: items}
+	resp := SearchResponse{Items: make([]*SearchResultV1, 0, len(items))}
+	for _, it := range items {
+		resp.Items = append(resp.Items, &SearchResultV1{
+			ItemV1:  *newItemV1(&it.Item, s.imageURL(r)),
+			Snippet: it.Snippet,
+			Score:   it.Score,
+		})
+	}
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("failed to write response: ", "error", err)
 		return
 	}
 
 	slog.Info("search completed", "keyword", keyword, "count", len(items))
 }
+
+type GetCategoriesResponse struct {
+	Categories []*Category `json:"categories"`
+}
+
+// GetCategories is a handler to return all categories with their item counts for GET /categories .
+func (s *Handlers) GetCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	categories, err := s.categoryRepo.List(ctx)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get categories: %w", err))
+		return
+	}
+
+	resp := GetCategoriesResponse{Categories: categories}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+		return
+	}
+}
+
+// GetCategory is a handler to return a category with its item count for GET /categories/{id} .
+func (s *Handlers) GetCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	category, err := s.categoryRepo.GetByID(ctx, id)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get category: %w", err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(category); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+// CategoryNode is a category with its subcategories in the category tree.
+type CategoryNode struct {
+	Category
+	// TotalItemCount is the number of items in the category and all its subcategories.
+	TotalItemCount int             `json:"total_item_count"`
+	Children       []*CategoryNode `json:"children"`
+}
+
+// buildCategoryTree builds the category tree and returns the root categories.
+// The order of categories is kept among siblings.
+func buildCategoryTree(categories []*Category) []*CategoryNode {
+	nodes := make(map[int]*CategoryNode, len(categories))
+	for _, c := range categories {
+		nodes[c.ID] = &CategoryNode{Category: *c, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, c := range categories {
+		node := nodes[c.ID]
+		var parent *CategoryNode
+		if c.ParentID != nil {
+			parent = nodes[*c.ParentID]
+		}
+		if parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	var countItems func(n *CategoryNode) int
+	countItems = func(n *CategoryNode) int {
+		n.TotalItemCount = n.ItemCount
+		for _, child := range n.Children {
+			n.TotalItemCount += countItems(child)
+		}
+		return n.TotalItemCount
+	}
+	for _, root := range roots {
+		countItems(root)
+	}
+	return roots
+}
+
+type GetCategoryTreeResponse struct {
+	Categories []*CategoryNode `json:"categories"`
+}
+
+// GetCategoryTree is a handler to return the category tree for GET /categories/tree .
+func (s *Handlers) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	categories, err := s.categoryRepo.List(ctx)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get categories: %w", err))
+		return
+	}
+
+	resp := GetCategoryTreeResponse{Categories: buildCategoryTree(categories)}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+		return
+	}
+}
+
+type CategoryRequest struct {
+	ID       int     // path value, zero on adding a category
+	Name     *string `form:"name"`      // nil if not specified
+	ParentID *int    `form:"parent_id"` // nil if not specified or empty
+	// HasParentID reports whether parent_id is specified. An empty parent_id makes the category a root category.
+	HasParentID bool
+}
+
+// parseCategoryRequest parses and validates the request to add or update a category.
+// If partial is false, the request is to add a category and name is required.
+func parseCategoryRequest(r *http.Request, partial bool) (*CategoryRequest, error) {
+	req := &CategoryRequest{}
+	if partial {
+		id, err := parsePathID(r)
+		if err != nil {
+			return nil, err
+		}
+		req.ID = id
+	}
+
+	// ParseMultipartForm drops the error of parsing a URL-encoded body, so it is parsed first
+	if err := r.ParseForm(); err != nil {
+		return nil, requestBodyError("failed to parse form", err)
+	}
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, requestBodyError("failed to parse form", err)
+	}
+	if r.PostForm.Has("name") {
+		name := strings.TrimSpace(r.PostForm.Get("name"))
+		req.Name = &name
+	}
+	if r.PostForm.Has("parent_id") {
+		req.HasParentID = true
+		if s := r.PostForm.Get("parent_id"); s != "" {
+			parentID, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, errors.New("parent_id must be an integer")
+			}
+			req.ParentID = &parentID
+		}
+	}
+
+	// validate the request
+	v := &validator{}
+	if partial && req.Name == nil && !req.HasParentID {
+		v.add("", fieldRequired, "at least one of name and parent_id is required")
+	} else if req.Name != nil || !partial {
+		checkField(v, "name", valueOrZero(req.Name), categoryNameRules...)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// AddCategory is a handler to add a new category for POST /categories .
+func (s *Handlers) AddCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	s.limitRequestBody(w, r, 0)
+	req, err := parseCategoryRequest(r, false)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	category := &Category{Name: *req.Name, ParentID: req.ParentID}
+	if err := s.categoryRepo.Create(ctx, category); err != nil {
+		writeError(w, r, fmt.Errorf("failed to add category: %w", err))
+		return
+	}
+	slog.Info("category added", "id", category.ID, "name", category.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/categories/%d", category.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(category); err != nil {
+		slog.Error("failed to encode category: ", "error", err)
+	}
+}
+
+// PatchCategory is a handler to rename a category or move it under another parent for PATCH /categories/{id} .
+func (s *Handlers) PatchCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	s.limitRequestBody(w, r, 0)
+	req, err := parseCategoryRequest(r, true)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	category, err := s.categoryRepo.GetByID(ctx, req.ID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get category: %w", err))
+		return
+	}
+	if req.Name != nil {
+		category.Name = *req.Name
+	}
+	if req.HasParentID {
+		category.ParentID = req.ParentID
+	}
+
+	if err := s.categoryRepo.Update(ctx, category); err != nil {
+		writeError(w, r, fmt.Errorf("failed to update category: %w", err))
+		return
+	}
+	slog.Info("category updated", "id", category.ID, "name", category.Name)
+
+	if err := json.NewEncoder(w).Encode(category); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+// DeleteCategory is a handler to delete a category without items for DELETE /categories/{id} .
+func (s *Handlers) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+
+	if err := s.categoryRepo.Delete(ctx, id); err != nil {
+		writeError(w, r, fmt.Errorf("failed to delete category: %w", err))
+		return
+	}
+	slog.Info("category deleted", "id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type MergeCategoryRequest struct {
+	ID int `json:"-"` // path value
+	// TargetID is the id of the category which takes over the items and the subcategories.
+	TargetID int `json:"target_id"`
+}
+
+// MergeCategory is a handler to merge a category into another one for POST /categories/{id}/merge .
+// The items and the subcategories are moved to the target, and the merged category is deleted.
+func (s *Handlers) MergeCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	req := &MergeCategoryRequest{ID: id}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	if req.TargetID <= 0 {
+		v := &validator{}
+		v.add("target_id", fieldRequired, "is required")
+		writeError(w, r, v.err())
+		return
+	}
+
+	if err := s.categoryRepo.Merge(ctx, req.ID, req.TargetID); err != nil {
+		writeError(w, r, fmt.Errorf("failed to merge category: %w", err))
+		return
+	}
+	slog.Info("category merged", "id", req.ID, "target_id", req.TargetID)
+
+	category, err := s.categoryRepo.GetByID(ctx, req.TargetID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get category: %w", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(category); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+// maxJSONBodyBytes is the maximum size of a request body in JSON.
+const maxJSONBodyBytes = 64 << 10
+
+// decodeJSONBody decodes the request body in JSON into v. A body larger than maxJSONBodyBytes is rejected,
+// and so are unknown fields, so that a misspelled field is not ignored silently.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return requestBodyError("invalid request body", err)
+	}
+	return nil
+}
+
+// UserV1 is the version 1 representation of a user in responses.
+type UserV1 struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newUserV1(user *User) *UserV1 {
+	return &UserV1{ID: user.ID, Email: user.Email, Name: user.Name, Role: user.Role, CreatedAt: user.CreatedAt}
+}
+
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// validate checks all the fields of the request, and returns all the violations together.
+func (req *RegisterRequest) validate() error {
+	v := &validator{}
+	checkField(v, "email", req.Email, emailRules...)
+	checkField(v, "name", req.Name, userNameRules...)
+	checkField(v, "password", req.Password, passwordRules...)
+	return v.err()
+}
+
+// Register is a handler to create a user for POST /auth/register .
+func (s *Handlers) Register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := &RegisterRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user := &User{Email: req.Email, Name: req.Name, PasswordHash: hash}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		writeError(w, r, fmt.Errorf("failed to create user: %w", err))
+		return
+	}
+	slog.Info("user registered", "id", user.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newUserV1(user)); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// TokenResponse is the tokens of a session in the style of OAuth 2.0.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int `json:"expires_in"`
+}
+
+func (s *Handlers) newTokenResponse(tokens *sessionTokens) TokenResponse {
+	return TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTokenTTL.Seconds()),
+	}
+}
+
+type LoginResponse struct {
+	User *UserV1 `json:"user"`
+	TokenResponse
+}
+
+// Login is a handler to authenticate a user by the email and the password for POST /auth/login .
+// It does not tell whether the email is registered, either by the response or by the response time.
+func (s *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := &LoginRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	v := &validator{}
+	checkField(v, "email", req.Email, required)
+	checkField(v, "password", req.Password, required, maxLength(maxPasswordLength))
+	if err := v.err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, req.Email)
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		writeError(w, r, fmt.Errorf("failed to get user: %w", err))
+		return
+	}
+	hash := dummyPasswordHash()
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	ok, err := verifyPassword(req.Password, hash)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to verify password: %w", err))
+		return
+	}
+	if !ok || user == nil {
+		slog.Info("login failed", "user_found", user != nil)
+		writeError(w, r, errInvalidCredentials)
+		return
+	}
+
+	// a login starts a new family of sessions, which is revoked together on logout
+	session, tokens, err := newSession(time.Now(), s.accessTokenTTL, s.refreshTokenTTL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	session.UserID = user.ID
+	if session.FamilyID, err = newToken(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		writeError(w, r, fmt.Errorf("failed to create session: %w", err))
+		return
+	}
+	slog.Info("user logged in", "id", user.ID, "session_id", session.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(LoginResponse{User: newUserV1(user), TokenResponse: s.newTokenResponse(tokens)}); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh is a handler to replace the tokens of a session with new ones for POST /auth/refresh .
+// A refresh token can be used only once, and reusing it revokes all the sessions from the same login.
+func (s *Handlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := &RefreshRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	v := &validator{}
+	checkField(v, "refresh_token", req.RefreshToken, required)
+	if err := v.err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	next, tokens, err := newSession(now, s.accessTokenTTL, s.refreshTokenTTL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = s.sessionRepo.Rotate(ctx, hashToken(req.RefreshToken), next, now)
+	switch {
+	case errors.Is(err, errRefreshTokenReused):
+		slog.Warn("refresh token reused, sessions revoked")
+		writeError(w, r, errInvalidToken)
+		return
+	case errors.Is(err, errSessionNotFound):
+		writeError(w, r, errInvalidToken)
+		return
+	case err != nil:
+		writeError(w, r, fmt.Errorf("failed to rotate session: %w", err))
+		return
+	}
+	slog.Info("session refreshed", "user_id", next.UserID, "session_id", next.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(s.newTokenResponse(tokens)); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+// Logout is a handler to revoke the session of the access token for POST /auth/logout .
+// The sessions refreshed from the same login are revoked together, so that the refresh token cannot be used either.
+func (s *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+	if session == nil {
+		writeError(w, r, errInvalidToken)
+		return
+	}
+	if err := s.sessionRepo.RevokeFamily(r.Context(), session.FamilyID); err != nil {
+		writeError(w, r, fmt.Errorf("failed to revoke sessions: %w", err))
+		return
+	}
+	slog.Info("user logged out", "user_id", session.UserID, "session_id", session.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type GetUsersResponse struct {
+	Users []*UserV1 `json:"users"`
+}
+
+// GetUsers is a handler to return all the users for GET /users .
+func (s *Handlers) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.userRepo.List(r.Context())
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get users: %w", err))
+		return
+	}
+
+	resp := GetUsersResponse{Users: make([]*UserV1, 0, len(users))}
+	for _, user := range users {
+		resp.Users = append(resp.Users, newUserV1(user))
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
+
+type SetUserRoleRequest struct {
+	ID   int  `json:"-"` // path value
+	Role Role `json:"role"`
+}
+
+// SetUserRole is a handler to change the role of a user for PUT /users/{id}/role .
+// Users cannot change their own role, so that the last admin is not demoted by mistake.
+func (s *Handlers) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	me := userFromContext(ctx)
+	if me == nil {
+		writeError(w, r, errUnauthenticated)
+		return
+	}
+	id, err := parsePathID(r)
+	if err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	req := &SetUserRoleRequest{ID: id}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeError(w, r, invalidRequest(err))
+		return
+	}
+	v := &validator{}
+	checkField(v, "role", string(req.Role), required)
+	if err := v.err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if me.ID == req.ID {
+		writeError(w, r, &APIError{Status: http.StatusForbidden, Code: codeForbidden, Message: "cannot change your own role"})
+		return
+	}
+
+	if err := s.userRepo.SetRole(ctx, req.ID, req.Role); err != nil {
+		writeError(w, r, fmt.Errorf("failed to set role: %w", err))
+		return
+	}
+	slog.Info("role changed", "id", req.ID, "role", req.Role, "by", me.ID)
+
+	user, err := s.userRepo.GetByID(ctx, req.ID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to get user: %w", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(newUserV1(user)); err != nil {
+		slog.Error("failed to write response: ", "error", err)
+	}
+}
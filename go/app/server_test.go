@@ -2,48 +2,184 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	gomock "go.uber.org/mock/gomock"
+	"image"
+	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseAddItemRequest(t *testing.T) {
 	t.Parallel()
 
+	jpegImage := encodeTestImage(t, "jpeg", 16, 16)
+
 	type wants struct {
 		req *AddItemRequest
-		err bool
+		// images are the contents of the uploaded images.
+		images [][]byte
+		err    bool
+		// fieldErrors are the fields and the codes of the violations if the request is invalid.
+		fieldErrors map[string]string
+		// wantErr is the error returned instead of the violations.
+		wantErr error
 	}
 
 	cases := map[string]struct {
-		args map[string]string
+		args   map[string]string
+		image  []byte
+		limits ImageLimits
 		wants
 	}{
 		"ok: valid request": {
 			args: map[string]string{
-				"name":     "jacket",
-				"category": "fashion",
-				"image":    "images/local_image.jpg",
+				"name":        "jacket",
+				"category":    "fashion",
+				"price":       "4800",
+				"condition":   "good",
+				"description": "warm and light\r\nsize M",
+				"brand":       "ACME",
+				"image":       "images/local_image.jpg",
 			},
+			image: jpegImage,
 			wants: wants{
 				req: &AddItemRequest{
-					Name:     "jacket",
-					Category: "fashion",
-					Image:    []byte("images/local_image.jpg"),
+					Name:        "jacket",
+					Category:    "fashion",
+					Price:       "4800",
+					Condition:   "good",
+					Description: "warm and light\nsize M",
+					Brand:       "ACME",
 				},
-				err: false,
+				images: [][]byte{jpegImage},
+				err:    false,
 			},
 		},
 		"ng: empty request": {
 			args: map[string]string{},
+			wants: wants{
+				req: nil,
+				err: true,
+				fieldErrors: map[string]string{
+					"name":      fieldRequired,
+					"category":  fieldRequired,
+					"price":     fieldRequired,
+					"condition": fieldRequired,
+					"image":     fieldRequired,
+				},
+			},
+		},
+		"ng: all violations": {
+			args: map[string]string{
+				"name":        strings.Repeat("a", maxItemNameLength+1),
+				"category":    "fashion\n",
+				"price":       "4,800",
+				"condition":   "mint",
+				"description": strings.Repeat("a", maxItemDescriptionLength+1),
+				"brand":       "ACME ",
+				"image":       "images/local_image.jpg",
+			},
+			image:  jpegImage,
+			limits: ImageLimits{MaxWidth: 8, MaxHeight: 8},
+			wants: wants{
+				req: nil,
+				err: true,
+				fieldErrors: map[string]string{
+					"name":        fieldTooLong,
+					"category":    fieldInvalidChars,
+					"price":       fieldInvalidFormat,
+					"condition":   fieldInvalidChoice,
+					"description": fieldTooLong,
+					"brand":       fieldSurroundingSpace,
+					"image[0]":    fieldImageDimensions,
+				},
+			},
+		},
+		"ng: unsupported image wins over the violations": {
+			args: map[string]string{
+				"name":     strings.Repeat("a", maxItemNameLength+1),
+				"category": "fashion",
+				"image":    "images/local_image.jpg",
+			},
+			image: []byte("not an image"),
+			wants: wants{
+				req:     nil,
+				err:     true,
+				wantErr: errUnsupportedImage,
+			},
+		},
+		"ng: surrounding whitespace": {
+			args: map[string]string{
+				"name":      " jacket",
+				"category":  "fashion",
+				"price":     "4800",
+				"condition": "good",
+				"image":     "images/local_image.jpg",
+			},
+			image: jpegImage,
+			wants: wants{
+				req:         nil,
+				err:         true,
+				fieldErrors: map[string]string{"name": fieldSurroundingSpace},
+			},
+		},
+		"ng: price out of range": {
+			args: map[string]string{
+				"name":      "jacket",
+				"category":  "fashion",
+				"price":     strconv.Itoa(maxItemPrice + 1),
+				"condition": "good",
+				"image":     "images/local_image.jpg",
+			},
+			image: jpegImage,
+			wants: wants{
+				req:         nil,
+				err:         true,
+				fieldErrors: map[string]string{"price": fieldOutOfRange},
+			},
+		},
+		"ng: too large dimensions": {
+			args: map[string]string{
+				"name":      "jacket",
+				"category":  "fashion",
+				"price":     "4800",
+				"condition": "good",
+				"image":     "images/local_image.jpg",
+			},
+			image:  jpegImage,
+			limits: ImageLimits{MaxWidth: 8, MaxHeight: 8},
+			wants: wants{
+				req:         nil,
+				err:         true,
+				fieldErrors: map[string]string{"image[0]": fieldImageDimensions},
+			},
+		},
+		"ng: too large image": {
+			args: map[string]string{
+				"name":      "jacket",
+				"category":  "fashion",
+				"price":     "4800",
+				"condition": "good",
+				"image":     "images/local_image.jpg",
+			},
+			image:  jpegImage,
+			limits: ImageLimits{MaxBytes: 10},
 			wants: wants{
 				req: nil,
 				err: true,
@@ -64,7 +200,7 @@ func TestParseAddItemRequest(t *testing.T) {
 					if err != nil {
 						t.Fatal(err)
 					}
-					fw.Write([]byte(v))
+					fw.Write(tt.image)
 				} else {
 					if err := w.WriteField(k, v); err != nil {
 						t.Fatal(err)
@@ -79,17 +215,49 @@ func TestParseAddItemRequest(t *testing.T) {
 			}
 			req.Header.Set("Content-Type", w.FormDataContentType())
 
-			got, err := parseAddItemRequest(req)
+			dir := t.TempDir()
+			got, err := parseAddItemRequest(req, imageUploader{dir: dir, limits: tt.limits})
 
 			if err != nil {
 				if !tt.err {
 					t.Errorf("unexpected error: %v", err)
 				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+				if tt.fieldErrors != nil {
+					var validationErr *ValidationError
+					if !errors.As(err, &validationErr) {
+						t.Fatalf("expected a validation error, got %v", err)
+					}
+					got := map[string]string{}
+					for _, fe := range validationErr.Errors {
+						got[fe.Field] = fe.Code
+					}
+					if diff := cmp.Diff(tt.fieldErrors, got); diff != "" {
+						t.Errorf("unexpected field errors (-want +got):\n%s", diff)
+					}
+				}
+				if files, _ := os.ReadDir(dir); len(files) > 0 {
+					t.Errorf("expected the uploaded files to be removed, got %d files", len(files))
+				}
 				return
 			}
-			if diff := cmp.Diff(tt.wants.req, got); diff != "" {
+			defer closeUploads(got.Images)
+			if diff := cmp.Diff(tt.wants.req, got, cmpopts.IgnoreFields(AddItemRequest{}, "Images")); diff != "" {
 				t.Errorf("unexpected request (-want +got):\n%s", diff)
 			}
+			var images [][]byte
+			for _, img := range got.Images {
+				b, err := os.ReadFile(img.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				images = append(images, b)
+			}
+			if diff := cmp.Diff(tt.wants.images, images); diff != "" {
+				t.Errorf("unexpected images (-want +got):\n%s", diff)
+			}
 		})
 	}
 }
@@ -125,39 +293,74 @@ func TestHelloHandler(t *testing.T) {
 	}
 }
 
+// testSeller is the user who lists the items in the tests of the handlers.
+var testSeller = &User{ID: 1, Email: "seller@example.com", Name: "seller", Role: RoleUser}
+
+// otherSeller is a user who does not own the items of testSeller.
+var otherSeller = &User{ID: 2, Email: "other@example.com", Name: "other", Role: RoleUser}
+
+// newUserRoles returns the repositories of roles and audit events, where only admins can change the items of others.
+func newUserRoles(ctrl *gomock.Controller) (*MockRoleRepository, *MockAuditRepository) {
+	roles := NewMockRoleRepository(ctrl)
+	roles.EXPECT().HasPermission(gomock.Any(), gomock.Any(), PermChangeAnyItem).DoAndReturn(
+		func(_ context.Context, role Role, _ Permission) (bool, error) { return role == RoleAdmin, nil }).AnyTimes()
+	audit := NewMockAuditRepository(ctrl)
+	audit.EXPECT().Record(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	return roles, audit
+}
+
+// withUser returns the request authenticated as the user as authMiddleware does.
+func withUser(req *http.Request, user *User) *http.Request {
+	return req.WithContext(withAuth(req.Context(), user, &Session{UserID: user.ID, FamilyID: "family"}))
+}
+
 func TestAddItem(t *testing.T) {
 	t.Parallel()
 
-	tmpDir := t.TempDir()
+	images := NewMemoryImageStore()
+	jpegImage := encodeTestImage(t, "jpeg", 16, 16)
 
 	type wants struct {
 		code int
 	}
 	cases := map[string]struct {
-		args     map[string]string
-		injector func(m *MockItemRepository, c *MockCategoryRepository)
+		args   map[string]string
+		image  []byte
+		strict bool
+		// anonymous sends the request without authentication.
+		anonymous bool
+		injector  func(m *MockItemRepository, c *MockCategoryRepository)
 		wants
 	}{
 		"ok: correctly inserted": {
 			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
-				"image":    "test.jpg",
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
 			},
+			image: jpegImage,
 			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
 				c.EXPECT().GetOrCreate(gomock.Any(), gomock.Any()).Return(1, nil)
-				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, item *Item) error {
+					item.ID = 1
+					return nil
+				})
 			},
 			wants: wants{
-				code: http.StatusOK,
+				code: http.StatusCreated,
 			},
 		},
 		"ng: failed to insert": {
 			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
-				"image":    "test.jpg",
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
 			},
+			image: jpegImage,
 			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
 				c.EXPECT().GetOrCreate(gomock.Any(), gomock.Any()).Return(1, nil)
 				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(errors.New("failed to insert"))
@@ -166,6 +369,70 @@ func TestAddItem(t *testing.T) {
 				code: http.StatusInternalServerError,
 			},
 		},
+		"ng: not an image": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
+			},
+			image:    []byte("test image data"),
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusUnsupportedMediaType,
+			},
+		},
+		"ok: known category in strict mode": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
+			},
+			image:  jpegImage,
+			strict: true,
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
+				c.EXPECT().GetByName(gomock.Any(), "phone").Return(&Category{ID: 1, Name: "phone"}, nil)
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusCreated,
+			},
+		},
+		"ng: not authenticated": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
+			},
+			image:     jpegImage,
+			anonymous: true,
+			injector:  func(m *MockItemRepository, c *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: unknown category in strict mode": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phnoe",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
+			},
+			image:  jpegImage,
+			strict: true,
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
+				c.EXPECT().GetByName(gomock.Any(), "phnoe").Return(nil, errCategoryNotFound)
+			},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
 	}
 
 	for name, tt := range cases {
@@ -178,9 +445,10 @@ func TestAddItem(t *testing.T) {
 			tt.injector(mockIR, mockCR)
 
 			h := &Handlers{
-				imgDirPath:   tmpDir,
-				itemRepo:     mockIR,
-				categoryRepo: mockCR,
+				images:           images,
+				itemRepo:         mockIR,
+				categoryRepo:     mockCR,
+				strictCategories: tt.strict,
 			}
 
 			var b bytes.Buffer
@@ -192,7 +460,7 @@ func TestAddItem(t *testing.T) {
 					if err != nil {
 						t.Fatal(err)
 					}
-					fw.Write([]byte(v))
+					fw.Write(tt.image)
 				} else {
 					if err := w.WriteField(k, v); err != nil {
 						t.Fatal(err)
@@ -203,6 +471,9 @@ func TestAddItem(t *testing.T) {
 
 			req := httptest.NewRequest("POST", "/items", &b)
 			req.Header.Set("Content-Type", w.FormDataContentType())
+			if !tt.anonymous {
+				req = withUser(req, testSeller)
+			}
 
 			rr := httptest.NewRecorder()
 			h.AddItem(rr, req)
@@ -214,14 +485,22 @@ func TestAddItem(t *testing.T) {
 				return
 			}
 
-			var resp AddItemResponse
+			var resp ItemV1
 			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
 				t.Fatalf("failed to decode response body: %v", err)
 			}
 
-			expectedMessage := fmt.Sprintf("item received: %s", tt.args["name"])
-			if resp.Message != expectedMessage {
-				t.Errorf("unexpected message, want %q, got %q", expectedMessage, resp.Message)
+			if resp.Name != tt.args["name"] {
+				t.Errorf("unexpected name, want %q, got %q", tt.args["name"], resp.Name)
+			}
+			if want := fmt.Sprintf("/items/%d", resp.ID); rr.Header().Get("Location") != want {
+				t.Errorf("unexpected location, want %q, got %q", want, rr.Header().Get("Location"))
+			}
+			if resp.SellerID == nil || *resp.SellerID != testSeller.ID {
+				t.Errorf("expected the seller %d, got %v", testSeller.ID, resp.SellerID)
+			}
+			if resp.Price == nil || *resp.Price != 45000 || resp.Condition == nil || *resp.Condition != ConditionLikeNew {
+				t.Errorf("expected the price and the condition, got %v and %v", resp.Price, resp.Condition)
 			}
 		})
 	}
@@ -242,31 +521,68 @@ func TestAddItemE2e(t *testing.T) {
 		}
 	})
 
+	jpegImage := encodeTestImage(t, "jpeg", 16, 16)
+
 	type wants struct {
 		code int
 	}
 	cases := map[string]struct {
-		args map[string]string
+		args  map[string]string
+		image []byte
 		wants
 	}{
 		"ok: correctly inserted": {
 			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
-				"image":    "test.jpg",
+				"name":        "used iPhone 16e",
+				"category":    "phone",
+				"price":       "45000",
+				"condition":   "like_new",
+				"description": "128GB, no scratches.\nComes with the box.",
+				"brand":       "Apple",
+				"image":       "test.jpg",
 			},
+			image: jpegImage,
 			wants: wants{
-				code: http.StatusOK,
+				code: http.StatusCreated,
+			},
+		},
+		"ok: png image": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.png",
+			},
+			image: encodeTestImage(t, "png", 16, 16),
+			wants: wants{
+				code: http.StatusCreated,
+			},
+		},
+		"ng: not an image": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
+			},
+			image: []byte("test image data"),
+			wants: wants{
+				code: http.StatusUnsupportedMediaType,
 			},
 		},
 		"ng: failed to insert": {
 			args: map[string]string{
-				"name":     "",
-				"category": "phone",
-				"image":    "test.jpg",
+				"name":      "",
+				"category":  "phone",
+				"price":     "45000",
+				"condition": "like_new",
+				"image":     "test.jpg",
 			},
+			image: jpegImage,
 			wants: wants{
-				code: http.StatusBadRequest,
+				code: http.StatusUnprocessableEntity,
 			},
 		},
 	}
@@ -274,7 +590,7 @@ func TestAddItemE2e(t *testing.T) {
 	for name, tt := range cases {
 		t.Run(name, func(t *testing.T) {
 			h := &Handlers{
-				imgDirPath:   t.TempDir(),
+				images:       NewLocalImageStore(t.TempDir()),
 				itemRepo:     NewItemRepository(db),
 				categoryRepo: NewCategoryRepository(db),
 			}
@@ -288,7 +604,7 @@ func TestAddItemE2e(t *testing.T) {
 					if err != nil {
 						t.Fatal(err)
 					}
-					fw.Write([]byte("test image data"))
+					fw.Write(tt.image)
 				} else {
 					if err := w.WriteField(k, v); err != nil {
 						t.Fatal(err)
@@ -299,6 +615,7 @@ func TestAddItemE2e(t *testing.T) {
 
 			req := httptest.NewRequest("POST", "/items", &b)
 			req.Header.Set("Content-Type", w.FormDataContentType())
+			req = withUser(req, testSeller)
 
 			rr := httptest.NewRecorder()
 			h.AddItem(rr, req)
@@ -310,14 +627,35 @@ func TestAddItemE2e(t *testing.T) {
 				return
 			}
 
-			var resp AddItemResponse
+			var resp ItemV1
 			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
 				t.Fatalf("failed to decode response body: %v", err)
 			}
 
-			expectedMessage := fmt.Sprintf("item received: %s", tt.args["name"])
-			if resp.Message != expectedMessage {
-				t.Errorf("unexpected message, want %q, got %q", expectedMessage, resp.Message)
+			if resp.Name != tt.args["name"] {
+				t.Errorf("unexpected name, want %q, got %q", tt.args["name"], resp.Name)
+			}
+			if want := fmt.Sprintf("/items/%d", resp.ID); rr.Header().Get("Location") != want {
+				t.Errorf("unexpected location, want %q, got %q", want, rr.Header().Get("Location"))
+			}
+			if want := filepath.Ext(tt.args["image"]); !strings.HasSuffix(resp.ImageURL, want) {
+				t.Errorf("expected the image to be stored with %s, got %q", want, resp.ImageURL)
+			}
+			if resp.Description != tt.args["description"] || resp.Brand != tt.args["brand"] {
+				t.Errorf("unexpected description and brand, got %q and %q", resp.Description, resp.Brand)
+			}
+
+			// the stored item is read back as it was added
+			req = httptest.NewRequest("GET", rr.Header().Get("Location"), nil)
+			req.SetPathValue("id", strconv.Itoa(resp.ID))
+			rr = httptest.NewRecorder()
+			h.GetItem(rr, req)
+			var got ItemV1
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if diff := cmp.Diff(resp, got); diff != "" {
+				t.Errorf("unexpected stored item (-want +got):\n%s", diff)
 			}
 		})
 	}
@@ -352,22 +690,2580 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
 	})
 
 	// Create tables
-	schema := `
-	CREATE TABLE IF NOT EXISTS categories (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name VARCHAR(255) NOT NULL UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS items (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name VARCHAR(255),
-		category_id INTEGER,
-		image_name VARCHAR(255),
-		FOREIGN KEY (category_id) REFERENCES categories(id)
-	);
-	`
-	if _, err := db.Exec(schema); err != nil {
+	if err := setupDatabase(t.Context(), db); err != nil {
 		return nil, nil, err
 	}
 
 	return db, closers, nil
 }
+
+func TestParseGetItemsRequest(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		req *GetItemsRequest
+		err bool
+	}
+
+	cases := map[string]struct {
+		query string
+		wants
+	}{
+		"ok: defaults": {
+			query: "",
+			wants: wants{
+				req: &GetItemsRequest{Limit: defaultItemsLimit, Sort: ItemSortNewest},
+			},
+		},
+		"ok: all parameters": {
+			query: "?limit=5&cursor=abc&sort=name",
+			wants: wants{
+				req: &GetItemsRequest{Limit: 5, Cursor: "abc", Sort: ItemSortName},
+			},
+		},
+		"ng: limit is not an integer": {
+			query: "?limit=ten",
+			wants: wants{err: true},
+		},
+		"ng: limit is too large": {
+			query: "?limit=1000",
+			wants: wants{err: true},
+		},
+		"ok: price sort": {
+			query: "?sort=price",
+			wants: wants{
+				req: &GetItemsRequest{Limit: defaultItemsLimit, Sort: ItemSortPrice},
+			},
+		},
+		"ng: unknown sort": {
+			query: "?sort=random",
+			wants: wants{err: true},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/items"+tt.query, nil)
+			got, err := parseGetItemsRequest(req)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatalf("expected an error, got %+v", got)
+			}
+			if diff := cmp.Diff(tt.wants.req, got); diff != "" {
+				t.Errorf("unexpected request (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListPageE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	categoryID, err := NewCategoryRepository(db).GetOrCreate(ctx, "fashion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	itemRepo := NewItemRepository(db)
+	// the prices are in the order of the names, and the items listed before prices have none
+	prices := map[string]int{"coat": 9800, "jacket": 9800, "apron": 300}
+	for _, name := range []string{"coat", "bag", "jacket", "apron", "dress"} {
+		it := &Item{Name: name, CategoryID: categoryID}
+		if price, ok := prices[name]; ok {
+			it.Price = &price
+		}
+		if err := itemRepo.Insert(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]struct {
+		sort ItemSort
+		want []string
+	}{
+		"newest": {
+			sort: ItemSortNewest,
+			want: []string{"dress", "apron", "jacket", "bag", "coat"},
+		},
+		"name": {
+			sort: ItemSortName,
+			want: []string{"apron", "bag", "coat", "dress", "jacket"},
+		},
+		"price": {
+			sort: ItemSortPrice,
+			want: []string{"apron", "coat", "jacket", "bag", "dress"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				got    []string
+				cursor string
+			)
+			for range len(tt.want) {
+				page, err := itemRepo.ListPage(ctx, ListItemsParams{Limit: 2, Cursor: cursor, Sort: tt.sort})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, it := range page.Items {
+					got = append(got, it.Name)
+				}
+				cursor = page.NextCursor
+				if cursor == "" {
+					break
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("cursor of another sort order", func(t *testing.T) {
+		page, err := itemRepo.ListPage(ctx, ListItemsParams{Limit: 2, Sort: ItemSortName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = itemRepo.ListPage(ctx, ListItemsParams{Limit: 2, Cursor: page.NextCursor, Sort: ItemSortNewest})
+		if !errors.Is(err, errInvalidCursor) {
+			t.Errorf("expected %v, got %v", errInvalidCursor, err)
+		}
+	})
+}
+
+func TestBuildMatchQuery(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query string
+		want  string
+	}{
+		"single word": {
+			query: "jacket",
+			want:  `"jacket"`,
+		},
+		"multiple words": {
+			query: "  denim   jacket ",
+			want:  `"denim" "jacket"`,
+		},
+		"prefix": {
+			query: "jack*",
+			want:  `"jack"*`,
+		},
+		"phrase": {
+			query: `"denim jacket" blue`,
+			want:  `"denim jacket" "blue"`,
+		},
+		"unterminated phrase": {
+			query: `blue "denim   jacket`,
+			want:  `"blue" "denim jacket"`,
+		},
+		"operators are quoted": {
+			query: "NOT jacket OR coat-",
+			want:  `"NOT" "jacket" "OR" "coat-"`,
+		},
+		"no searchable terms": {
+			query: `* "" -`,
+			want:  ``,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := buildMatchQuery(parseSearchQuery(tt.query))
+			if got != tt.want {
+				t.Errorf("unexpected match query, want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSearchByKeywordE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	categoryRepo := NewCategoryRepository(db)
+	itemRepo := NewItemRepository(db)
+	for _, it := range []struct{ name, category string }{
+		{"blue denim jacket", "fashion"},
+		{"denim skirt", "fashion"},
+		{"jacket potato recipe book", "books"},
+		{"used iPhone 16e", "phone"},
+	} {
+		categoryID, err := categoryRepo.GetOrCreate(ctx, it.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := itemRepo.Insert(ctx, &Item{Name: it.name, CategoryID: categoryID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]struct {
+		query string
+		want  []string
+	}{
+		"multiple words": {
+			query: "denim jacket",
+			want:  []string{"blue denim jacket"},
+		},
+		"prefix": {
+			query: "jack*",
+			want:  []string{"blue denim jacket", "jacket potato recipe book"},
+		},
+		"phrase": {
+			query: `"potato recipe"`,
+			want:  []string{"jacket potato recipe book"},
+		},
+		"category name": {
+			query: "fashion",
+			want:  []string{"blue denim jacket", "denim skirt"},
+		},
+		"no match": {
+			query: "laptop",
+			want:  nil,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			results, err := itemRepo.SearchByKeyword(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, res := range results {
+				got = append(got, res.Name)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("ranking and snippet", func(t *testing.T) {
+		if !hasSearchIndex(db) {
+			t.Skip("FTS5 is not available, run with -tags sqlite_fts5")
+		}
+		results, err := itemRepo.SearchByKeyword(ctx, "jacket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+		if results[0].Score < results[1].Score {
+			t.Errorf("results are not ordered by score: %v, %v", results[0].Score, results[1].Score)
+		}
+		for _, res := range results {
+			if !strings.Contains(res.Snippet, "<mark>jacket</mark>") {
+				t.Errorf("snippet does not highlight the match: %q", res.Snippet)
+			}
+		}
+	})
+}
+
+func TestPatchItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+		item *ItemV1
+	}
+	newPrice, newCondition := 39800, ConditionFair
+	cases := map[string]struct {
+		id       string
+		args     map[string]string
+		injector func(m *MockItemRepository, c *MockCategoryRepository)
+		wants
+	}{
+		"ok: name and category updated": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16",
+				"category": "smartphone",
+			},
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "used iPhone 16e", Category: "phone", CategoryID: 1, SellerID: &testSeller.ID, ImageName: "a.jpg"}, nil)
+				c.EXPECT().GetOrCreate(gomock.Any(), "smartphone").Return(2, nil)
+				m.EXPECT().Update(gomock.Any(), &Item{ID: 1, Name: "used iPhone 16", Category: "smartphone", CategoryID: 2, SellerID: &testSeller.ID, ImageName: "a.jpg"}, nil).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				item: &ItemV1{ID: 1, Name: "used iPhone 16", Category: "smartphone", CategoryID: 2, SellerID: &testSeller.ID, ImageURL: "http://example.com/images/a.jpg"},
+			},
+		},
+		"ok: listing details updated": {
+			id: "1",
+			args: map[string]string{
+				"price":     "39800",
+				"condition": "fair",
+				"brand":     "",
+			},
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
+				price, condition := 45000, ConditionLikeNew
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "used iPhone 16e", SellerID: &testSeller.ID, Price: &price, Condition: &condition, Description: "128GB", Brand: "Apple"}, nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any(), nil).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				item: &ItemV1{ID: 1, Name: "used iPhone 16e", SellerID: &testSeller.ID, Price: &newPrice, Condition: &newCondition, Description: "128GB", ImageURL: "http://example.com/images/default.jpg"},
+			},
+		},
+		"ng: not the seller": {
+			id: "3",
+			args: map[string]string{
+				"name": "used iPhone 16",
+			},
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
+				m.EXPECT().Select(gomock.Any(), 3).Return(&Item{ID: 3, Name: "used iPhone 16e", SellerID: &otherSeller.ID}, nil)
+			},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			args: map[string]string{
+				"name": "used iPhone 16",
+			},
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {
+				m.EXPECT().Select(gomock.Any(), 2).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: no fields": {
+			id:       "1",
+			args:     map[string]string{},
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: empty name": {
+			id: "1",
+			args: map[string]string{
+				"name": "",
+			},
+			injector: func(m *MockItemRepository, c *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockIR, mockCR)
+
+			mockRR, mockAR := newUserRoles(ctrl)
+
+			h := &Handlers{
+				images:       NewMemoryImageStore(),
+				itemRepo:     mockIR,
+				categoryRepo: mockCR,
+				roleRepo:     mockRR,
+				auditRepo:    mockAR,
+			}
+
+			var b bytes.Buffer
+			w := multipart.NewWriter(&b)
+			for k, v := range tt.args {
+				if err := w.WriteField(k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w.Close()
+
+			req := httptest.NewRequest("PATCH", "/items/"+tt.id, &b)
+			req.Header.Set("Content-Type", w.FormDataContentType())
+			req.SetPathValue("id", tt.id)
+			req = withUser(req, testSeller)
+
+			rr := httptest.NewRecorder()
+			h.PatchItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var got ItemV1
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.item, &got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected item (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	t.Parallel()
+
+	admin := &User{ID: 9, Email: "admin@example.com", Name: "admin", Role: RoleAdmin}
+	cases := map[string]struct {
+		id string
+		// user is the authenticated user, which is testSeller if nil.
+		user     *User
+		injector func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository)
+		code     int
+	}{
+		"ok: deleted": {
+			id: "1",
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, SellerID: &testSeller.ID}, nil)
+				m.EXPECT().Delete(gomock.Any(), 1).Return(nil)
+			},
+			code: http.StatusNoContent,
+		},
+		"ok: admin deletes an item without a seller": {
+			id:   "4",
+			user: admin,
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {
+				m.EXPECT().Select(gomock.Any(), 4).Return(&Item{ID: 4}, nil)
+				rr.EXPECT().HasPermission(gomock.Any(), RoleAdmin, PermChangeAnyItem).Return(true, nil)
+				m.EXPECT().Delete(gomock.Any(), 4).Return(nil)
+			},
+			code: http.StatusNoContent,
+		},
+		"ng: not the seller": {
+			id: "5",
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {
+				m.EXPECT().Select(gomock.Any(), 5).Return(&Item{ID: 5, SellerID: &otherSeller.ID}, nil)
+				rr.EXPECT().HasPermission(gomock.Any(), RoleUser, PermChangeAnyItem).Return(false, nil)
+				a.EXPECT().Record(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, event *AuditEvent) error {
+					want := &AuditEvent{UserID: &testSeller.ID, Action: "DELETE /items/5", Permission: PermChangeAnyItem, Outcome: auditDenied, RemoteAddr: "192.0.2.1:1234"}
+					if diff := cmp.Diff(want, event); diff != "" {
+						t.Errorf("unexpected audit event (-want +got):\n%s", diff)
+					}
+					return nil
+				})
+			},
+			code: http.StatusForbidden,
+		},
+		"ng: item without a seller": {
+			id: "4",
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {
+				m.EXPECT().Select(gomock.Any(), 4).Return(&Item{ID: 4}, nil)
+				rr.EXPECT().HasPermission(gomock.Any(), RoleUser, PermChangeAnyItem).Return(false, nil)
+				a.EXPECT().Record(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			code: http.StatusForbidden,
+		},
+		"ng: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {
+				m.EXPECT().Select(gomock.Any(), 2).Return(nil, errItemNotFound)
+			},
+			code: http.StatusNotFound,
+		},
+		"ng: invalid id": {
+			id:       "abc",
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {},
+			code:     http.StatusBadRequest,
+		},
+		"ng: internal error": {
+			id: "3",
+			injector: func(m *MockItemRepository, rr *MockRoleRepository, a *MockAuditRepository) {
+				m.EXPECT().Select(gomock.Any(), 3).Return(&Item{ID: 3, SellerID: &testSeller.ID}, nil)
+				m.EXPECT().Delete(gomock.Any(), 3).Return(errors.New("database is locked"))
+			},
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			mockRR := NewMockRoleRepository(ctrl)
+			mockAR := NewMockAuditRepository(ctrl)
+			tt.injector(mockIR, mockRR, mockAR)
+
+			h := &Handlers{itemRepo: mockIR, roleRepo: mockRR, auditRepo: mockAR}
+
+			user := tt.user
+			if user == nil {
+				user = testSeller
+			}
+			req := httptest.NewRequest("DELETE", "/items/"+tt.id, nil)
+			req.SetPathValue("id", tt.id)
+			req = withUser(req, user)
+
+			rr := httptest.NewRecorder()
+			h.DeleteItem(rr, req)
+
+			if tt.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.code, rr.Code)
+			}
+			if strings.Contains(rr.Body.String(), "database") {
+				t.Errorf("expected the internal error not to be echoed, got %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdateAndDeleteItemE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	categoryRepo := NewCategoryRepository(db)
+	itemRepo := NewItemRepository(db)
+
+	phoneID, err := categoryRepo.GetOrCreate(ctx, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := &Item{Name: "used iPhone 16e", CategoryID: phoneID, SellerID: &testSeller.ID, ImageName: "a.jpg"}
+	if err := itemRepo.Insert(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+
+	fashionID, err := categoryRepo.GetOrCreate(ctx, "fashion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the seller is not changed by Update
+	price, condition := 1200, ConditionNew
+	want := &Item{
+		ID:          item.ID,
+		Name:        "phone case",
+		Category:    "fashion",
+		CategoryID:  fashionID,
+		SellerID:    &testSeller.ID,
+		Price:       &price,
+		Condition:   &condition,
+		Description: "unopened",
+		Brand:       "Apple",
+		ImageName:   "b.jpg",
+	}
+	if err := itemRepo.Update(ctx, want, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := itemRepo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the images are not changed by Update
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Item{}, "CreatedAt", "UpdatedAt", "Images")); diff != "" {
+		t.Errorf("unexpected item (-want +got):\n%s", diff)
+	}
+	if got.UpdatedAt.IsZero() || got.UpdatedAt.Before(got.CreatedAt) {
+		t.Errorf("unexpected timestamps, created at %v, updated at %v", got.CreatedAt, got.UpdatedAt)
+	}
+
+	// the fields are not changed if the images cannot be replaced
+	broken := *want
+	broken.Name = "broken"
+	if err := itemRepo.Update(ctx, &broken, make([]string, maxItemImages+1)); !errors.Is(err, errTooManyImages) {
+		t.Errorf("expected %v on too many images, got %v", errTooManyImages, err)
+	}
+	if got, err := itemRepo.Select(ctx, item.ID); err != nil || got.Name != want.Name {
+		t.Errorf("expected the item to be unchanged, got %+v, %v", got, err)
+	}
+	if err := itemRepo.Update(ctx, want, []string{"c.jpg", "d.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := itemRepo.Select(ctx, item.ID); err != nil || got.ImageName != "c.jpg" || len(got.Images) != 2 {
+		t.Errorf("expected the images to be replaced, got %+v, %v", got, err)
+	}
+
+	if err := itemRepo.Delete(ctx, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := itemRepo.Select(ctx, item.ID); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected %v after delete, got %v", errItemNotFound, err)
+	}
+	if err := itemRepo.Update(ctx, want, nil); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected %v on updating a deleted item, got %v", errItemNotFound, err)
+	}
+	if err := itemRepo.Delete(ctx, item.ID); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected %v on deleting a deleted item, got %v", errItemNotFound, err)
+	}
+}
+
+func TestNewItemV1(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		item   *Item
+		images ImageStore
+		want   *ItemV1
+	}{
+		"image name": {
+			item: &Item{ID: 1, Name: "jacket", Category: "fashion", CategoryID: 2, ImageName: "abc.jpg", CreatedAt: createdAt, UpdatedAt: createdAt},
+			want: &ItemV1{ID: 1, Name: "jacket", Category: "fashion", CategoryID: 2, ImageURL: "https://example.com/images/abc.jpg", CreatedAt: createdAt, UpdatedAt: createdAt},
+		},
+		"multiple images": {
+			item: &Item{ID: 1, ImageName: "b.jpg", Images: []*ItemImage{
+				{ID: 3, ImageName: "a.jpg", Position: 0},
+				{ID: 2, ImageName: "b.jpg", Position: 1, IsCover: true},
+			}},
+			want: &ItemV1{ID: 1, ImageURL: "https://example.com/images/b.jpg", Images: []*ItemImageV1{
+				{ID: 3, URL: "https://example.com/images/a.jpg", Position: 0},
+				{ID: 2, URL: "https://example.com/images/b.jpg", Position: 1, IsCover: true},
+			}},
+		},
+		"legacy image path": {
+			item: &Item{ID: 1, ImageName: "images/abc.jpg"},
+			want: &ItemV1{ID: 1, ImageURL: "https://example.com/images/abc.jpg"},
+		},
+		"no image": {
+			item: &Item{ID: 1},
+			want: &ItemV1{ID: 1, ImageURL: "https://example.com/images/default.jpg"},
+		},
+		"image served by the store": {
+			item:   &Item{ID: 1, ImageName: "abc.jpg"},
+			images: &S3ImageStore{cfg: S3Config{PublicURL: "https://cdn.example.com/", Prefix: "images/"}},
+			want:   &ItemV1{ID: 1, ImageURL: "https://cdn.example.com/images/abc.jpg"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{images: tt.images}
+			if h.images == nil {
+				h.images = NewMemoryImageStore()
+			}
+			req := httptest.NewRequest("GET", "/items/1", nil)
+			req.Host = "example.com"
+			req.Header.Set("X-Forwarded-Proto", "https")
+
+			got := newItemV1(tt.item, h.imageURL(req))
+			if diff := cmp.Diff(tt.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected item (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		id       string
+		injector func(c *MockCategoryRepository)
+		code     int
+	}{
+		"ok: deleted": {
+			id: "1",
+			injector: func(c *MockCategoryRepository) {
+				c.EXPECT().Delete(gomock.Any(), 1).Return(nil)
+			},
+			code: http.StatusNoContent,
+		},
+		"ng: category not found": {
+			id: "2",
+			injector: func(c *MockCategoryRepository) {
+				c.EXPECT().Delete(gomock.Any(), 2).Return(errCategoryNotFound)
+			},
+			code: http.StatusNotFound,
+		},
+		"ng: category in use": {
+			id: "3",
+			injector: func(c *MockCategoryRepository) {
+				c.EXPECT().Delete(gomock.Any(), 3).Return(errCategoryInUse)
+			},
+			code: http.StatusConflict,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockCR)
+
+			h := &Handlers{categoryRepo: mockCR}
+
+			req := httptest.NewRequest("DELETE", "/categories/"+tt.id, nil)
+			req.SetPathValue("id", tt.id)
+
+			rr := httptest.NewRecorder()
+			h.DeleteCategory(rr, req)
+
+			if tt.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAddCategory(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body        string
+		contentType string
+		injector    func(c *MockCategoryRepository)
+		code        int
+	}{
+		"ok: added": {
+			body:        "name=fashion",
+			contentType: "application/x-www-form-urlencoded",
+			injector: func(c *MockCategoryRepository) {
+				c.EXPECT().Create(gomock.Any(), &Category{Name: "fashion"}).Return(nil)
+			},
+			code: http.StatusCreated,
+		},
+		"ng: empty name": {
+			body:        "name=",
+			contentType: "application/x-www-form-urlencoded",
+			injector:    func(c *MockCategoryRepository) {},
+			code:        http.StatusUnprocessableEntity,
+		},
+		"ng: too large url-encoded body": {
+			body:        "name=fashion&note=" + strings.Repeat("a", maxFormOverhead),
+			contentType: "application/x-www-form-urlencoded",
+			injector:    func(c *MockCategoryRepository) {},
+			code:        http.StatusRequestEntityTooLarge,
+		},
+		"ng: too large multipart body": {
+			body: "--b\r\nContent-Disposition: form-data; name=\"note\"\r\n\r\n" +
+				strings.Repeat("a", maxFormOverhead) + "\r\n--b--\r\n",
+			contentType: "multipart/form-data; boundary=b",
+			injector:    func(c *MockCategoryRepository) {},
+			code:        http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockCR)
+
+			h := &Handlers{categoryRepo: mockCR}
+
+			req := httptest.NewRequest("POST", "/categories", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+			h.AddCategory(rr, req)
+
+			if tt.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCategoryRepositoryE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	categoryRepo := NewCategoryRepository(db)
+	itemRepo := NewItemRepository(db)
+
+	fashion := &Category{Name: "fashion"}
+	if err := categoryRepo.Create(ctx, fashion); err != nil {
+		t.Fatal(err)
+	}
+	if err := categoryRepo.Create(ctx, &Category{Name: "fashion"}); !errors.Is(err, errCategoryExists) {
+		t.Errorf("expected %v on creating a duplicate, got %v", errCategoryExists, err)
+	}
+	books := &Category{Name: "book"}
+	if err := categoryRepo.Create(ctx, books); err != nil {
+		t.Fatal(err)
+	}
+	if err := itemRepo.Insert(ctx, &Item{Name: "jacket", CategoryID: fashion.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	books.Name = "books"
+	if err := categoryRepo.Update(ctx, books); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := categoryRepo.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Category{
+		{ID: books.ID, Name: "books", ItemCount: 0},
+		{ID: fashion.ID, Name: "fashion", ItemCount: 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected categories (-want +got):\n%s", diff)
+	}
+
+	if err := categoryRepo.Delete(ctx, fashion.ID); !errors.Is(err, errCategoryInUse) {
+		t.Errorf("expected %v on deleting a category in use, got %v", errCategoryInUse, err)
+	}
+	if err := categoryRepo.Delete(ctx, books.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := categoryRepo.Delete(ctx, books.ID); !errors.Is(err, errCategoryNotFound) {
+		t.Errorf("expected %v on deleting a deleted category, got %v", errCategoryNotFound, err)
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	t.Parallel()
+
+	id := func(i int) *int { return &i }
+	categories := []*Category{
+		{ID: 1, Name: "fashion", ItemCount: 1},
+		{ID: 2, Name: "jackets", ParentID: id(3), ItemCount: 4},
+		{ID: 3, Name: "men", ParentID: id(1), ItemCount: 2},
+		{ID: 4, Name: "phone", ItemCount: 3},
+		{ID: 5, Name: "women", ParentID: id(1)},
+	}
+
+	want := []*CategoryNode{
+		{
+			Category:       *categories[0],
+			TotalItemCount: 7,
+			Children: []*CategoryNode{
+				{
+					Category:       *categories[2],
+					TotalItemCount: 6,
+					Children: []*CategoryNode{
+						{Category: *categories[1], TotalItemCount: 4, Children: []*CategoryNode{}},
+					},
+				},
+				{Category: *categories[4], TotalItemCount: 0, Children: []*CategoryNode{}},
+			},
+		},
+		{Category: *categories[3], TotalItemCount: 3, Children: []*CategoryNode{}},
+	}
+
+	got := buildCategoryTree(categories)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected tree (-want +got):\n%s", diff)
+	}
+}
+
+func TestCategoryTreeE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	categoryRepo := NewCategoryRepository(db)
+	itemRepo := NewItemRepository(db)
+
+	// fashion > men > jackets, and phone
+	fashion := &Category{Name: "fashion"}
+	if err := categoryRepo.Create(ctx, fashion); err != nil {
+		t.Fatal(err)
+	}
+	men := &Category{Name: "men", ParentID: &fashion.ID}
+	if err := categoryRepo.Create(ctx, men); err != nil {
+		t.Fatal(err)
+	}
+	jackets := &Category{Name: "jackets", ParentID: &men.ID}
+	if err := categoryRepo.Create(ctx, jackets); err != nil {
+		t.Fatal(err)
+	}
+	phone := &Category{Name: "phone"}
+	if err := categoryRepo.Create(ctx, phone); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range []*Item{
+		{Name: "scarf", CategoryID: fashion.ID},
+		{Name: "shirt", CategoryID: men.ID},
+		{Name: "denim jacket", CategoryID: jackets.ID},
+		{Name: "iPhone", CategoryID: phone.ID},
+	} {
+		if err := itemRepo.Insert(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]struct {
+		categoryID int
+		want       []string
+	}{
+		"root category": {
+			categoryID: fashion.ID,
+			want:       []string{"denim jacket", "scarf", "shirt"},
+		},
+		"intermediate category": {
+			categoryID: men.ID,
+			want:       []string{"denim jacket", "shirt"},
+		},
+		"leaf category": {
+			categoryID: jackets.ID,
+			want:       []string{"denim jacket"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			page, err := itemRepo.ListPage(ctx, ListItemsParams{Limit: 10, Sort: ItemSortName, CategoryID: tt.categoryID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, it := range page.Items {
+				got = append(got, it.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("move under a subcategory", func(t *testing.T) {
+		fashion.ParentID = &jackets.ID
+		if err := categoryRepo.Update(ctx, fashion); !errors.Is(err, errCategoryCycle) {
+			t.Errorf("expected %v, got %v", errCategoryCycle, err)
+		}
+	})
+
+	t.Run("unknown parent", func(t *testing.T) {
+		unknown := 100
+		if err := categoryRepo.Create(ctx, &Category{Name: "women", ParentID: &unknown}); !errors.Is(err, errParentNotFound) {
+			t.Errorf("expected %v, got %v", errParentNotFound, err)
+		}
+	})
+
+	t.Run("delete a category with subcategories", func(t *testing.T) {
+		if err := itemRepo.Delete(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := categoryRepo.Delete(ctx, fashion.ID); !errors.Is(err, errCategoryInUse) {
+			t.Errorf("expected %v, got %v", errCategoryInUse, err)
+		}
+	})
+}
+
+func TestSimpleCORSMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		origins []string
+		origin  string
+		want    string
+	}{
+		"allowed origin": {
+			origins: []string{"https://a.example.com", "https://b.example.com"},
+			origin:  "https://b.example.com",
+			want:    "https://b.example.com",
+		},
+		"disallowed origin": {
+			origins: []string{"https://a.example.com"},
+			origin:  "https://evil.example.com",
+			want:    "",
+		},
+		"wildcard": {
+			origins: []string{"*"},
+			origin:  "https://evil.example.com",
+			want:    "*",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("GET", "/items", nil)
+			req.Header.Set("Origin", tt.origin)
+			rr := httptest.NewRecorder()
+			simpleCORSMiddleware(next, tt.origins, []string{"GET"}).ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Errorf("unexpected allowed origin, want %q, got %q", tt.want, got)
+			}
+			for _, header := range []string{imageFallbackHeader, requestIDHeader} {
+				if got := rr.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, header) {
+					t.Errorf("expected %s to be exposed, got %q", header, got)
+				}
+			}
+		})
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	t.Parallel()
+
+	h := &Handlers{db: fakePinger{}, images: NewMemoryImageStore()}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /readyz", h.Ready)
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, &http.Server{Handler: mux}, ln, h, 100*time.Millisecond, 5*time.Second)
+	}()
+
+	if res, err := http.Get(url + "/readyz"); err != nil {
+		t.Fatal(err)
+	} else if res.Body.Close(); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected ready before shutdown, got %d", res.StatusCode)
+	}
+
+	// start an in-flight request and shut down while it is running
+	slow := make(chan string, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		slow <- string(b)
+	}()
+	<-started
+	cancel()
+
+	// readiness fails during the delay before the listener is closed
+	time.Sleep(20 * time.Millisecond)
+	if res, err := http.Get(url + "/readyz"); err != nil {
+		t.Fatal(err)
+	} else if res.Body.Close(); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready during shutdown, got %d", res.StatusCode)
+	}
+
+	close(release)
+	if got := <-slow; got != "done" {
+		t.Errorf("expected the in-flight request to complete, got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type fakePinger struct {
+	err error
+}
+
+func (p fakePinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+func TestReady(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		db       pinger
+		injector func(m *MockImageStore)
+		draining bool
+		wantCode int
+		wantBody ReadinessResponse
+	}{
+		"ok: all dependencies are available": {
+			db: fakePinger{},
+			injector: func(m *MockImageStore) {
+				m.EXPECT().Put(gomock.Any(), ".readyz", gomock.Any()).Return(nil)
+				m.EXPECT().Delete(gomock.Any(), ".readyz").Return(nil)
+			},
+			wantCode: http.StatusOK,
+			wantBody: ReadinessResponse{Status: "ok", Checks: map[string]string{"db": "ok", "images": "ok"}},
+		},
+		"ng: database does not respond": {
+			db: fakePinger{err: errors.New("database is locked")},
+			injector: func(m *MockImageStore) {
+				m.EXPECT().Put(gomock.Any(), ".readyz", gomock.Any()).Return(nil)
+				m.EXPECT().Delete(gomock.Any(), ".readyz").Return(nil)
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: ReadinessResponse{Status: "unavailable", Checks: map[string]string{"db": "failed", "images": "ok"}},
+		},
+		"ng: image store is not writable": {
+			db: fakePinger{},
+			injector: func(m *MockImageStore) {
+				m.EXPECT().Put(gomock.Any(), ".readyz", gomock.Any()).Return(errors.New("permission denied"))
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: ReadinessResponse{Status: "unavailable", Checks: map[string]string{"db": "ok", "images": "failed"}},
+		},
+		"ng: shutting down": {
+			db:       fakePinger{},
+			injector: func(m *MockImageStore) {},
+			draining: true,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: ReadinessResponse{Status: "shutting down"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			images := NewMockImageStore(ctrl)
+			tt.injector(images)
+
+			h := &Handlers{db: tt.db, images: images}
+			h.draining.Store(tt.draining)
+
+			req := httptest.NewRequest("GET", "/readyz", nil)
+			rr := httptest.NewRecorder()
+			h.Ready(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rr.Code)
+			}
+			var got ReadinessResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantBody, got); diff != "" {
+				t.Errorf("unexpected response (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewVersionResponse(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		info *debug.BuildInfo
+		ok   bool
+		want VersionResponse
+	}{
+		"ok: built from a repository": {
+			info: &debug.BuildInfo{
+				GoVersion: "go1.24.0",
+				Main:      debug.Module{Path: "mercari-build-training", Version: "v1.2.3"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs", Value: "git"},
+					{Key: "vcs.revision", Value: "0123456789abcdef"},
+					{Key: "vcs.time", Value: "2025-01-02T03:04:05Z"},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			ok: true,
+			want: VersionResponse{
+				Version:    "v1.2.3",
+				GoVersion:  "go1.24.0",
+				Revision:   "0123456789abcdef",
+				Modified:   true,
+				CommitTime: "2025-01-02T03:04:05Z",
+			},
+		},
+		"ok: no build info": {
+			ok:   false,
+			want: VersionResponse{Version: "unknown"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := newVersionResponse(tt.info, tt.ok)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected version (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	t.Parallel()
+
+	images := NewMemoryImageStore()
+	hashed := encodeTestImage(t, "jpeg", 300, 300)
+	hashedName := hashImageName(hashed, ".jpg")
+	hash := strings.TrimSuffix(hashedName, ".jpg")
+	for name, img := range map[string][]byte{
+		"photo.png":   encodeTestImage(t, "png", 800, 400),
+		"default.jpg": encodeTestImage(t, "jpeg", 600, 600),
+		hashedName:    hashed,
+	} {
+		if err := images.Put(t.Context(), name, bytes.NewReader(img)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &Handlers{images: images}
+
+	type wants struct {
+		code         int
+		contentType  string
+		width        int
+		etag         string
+		cacheControl string
+		fallback     bool
+	}
+	cases := map[string]struct {
+		filename    string
+		query       string
+		ifNoneMatch string
+		wants
+	}{
+		"ok: original": {
+			filename: "photo.png",
+			wants:    wants{code: http.StatusOK, contentType: "image/png", width: 800, cacheControl: "no-cache"},
+		},
+		"ok: variant": {
+			filename: "photo.png",
+			query:    "?w=400",
+			wants:    wants{code: http.StatusOK, contentType: "image/png", width: 400, cacheControl: "no-cache"},
+		},
+		"ok: variant of the default image": {
+			filename: "missing.jpg",
+			query:    "?w=100",
+			wants:    wants{code: http.StatusOK, contentType: "image/jpeg", width: 150, cacheControl: "no-cache", fallback: true},
+		},
+		"ok: default image": {
+			filename: hashImageName([]byte("missing"), ".jpg"),
+			wants:    wants{code: http.StatusOK, contentType: "image/jpeg", width: 600, cacheControl: "no-cache", fallback: true},
+		},
+		"ok: hashed original": {
+			filename: hashedName,
+			wants:    wants{code: http.StatusOK, contentType: "image/jpeg", width: 300, etag: `"` + hash + `"`, cacheControl: immutableCacheControl},
+		},
+		"ok: hashed variant": {
+			filename: hashedName,
+			query:    "?w=200",
+			wants:    wants{code: http.StatusOK, contentType: "image/jpeg", width: 300, etag: `"` + hash + `-w400"`, cacheControl: immutableCacheControl},
+		},
+		"ok: wider than the variants": {
+			filename: hashedName,
+			query:    "?w=2000",
+			wants:    wants{code: http.StatusOK, contentType: "image/jpeg", width: 300, etag: `"` + hash + `"`, cacheControl: immutableCacheControl},
+		},
+		"ok: not modified": {
+			filename:    hashedName,
+			ifNoneMatch: `"other", W/"` + hash + `"`,
+			wants:       wants{code: http.StatusNotModified, etag: `"` + hash + `"`, cacheControl: immutableCacheControl},
+		},
+		"ok: modified variant": {
+			filename:    hashedName,
+			query:       "?w=150",
+			ifNoneMatch: `"` + hash + `"`,
+			wants:       wants{code: http.StatusOK, contentType: "image/jpeg", width: 150, etag: `"` + hash + `-w150"`, cacheControl: immutableCacheControl},
+		},
+		"ng: invalid width": {
+			filename: "photo.png",
+			query:    "?w=large",
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: not an image extension": {
+			filename: "photo.txt",
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: directory traversal": {
+			filename: "../db/mercari.sqlite3",
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: variant": {
+			filename: "variants/photo_w400.png",
+			wants:    wants{code: http.StatusBadRequest},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/images/"+tt.filename+tt.query, nil)
+			req.SetPathValue("filename", tt.filename)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			h.GetImage(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+			if got := rr.Header().Get("ETag"); got != tt.wants.etag {
+				t.Errorf("unexpected ETag, want %q, got %q", tt.wants.etag, got)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tt.wants.cacheControl {
+				t.Errorf("unexpected Cache-Control, want %q, got %q", tt.wants.cacheControl, got)
+			}
+			if got := rr.Header().Get(imageFallbackHeader) != ""; got != tt.wants.fallback {
+				t.Errorf("unexpected fallback, want %v, got %v", tt.wants.fallback, got)
+			}
+			if tt.wants.code == http.StatusNotModified {
+				if rr.Body.Len() > 0 {
+					t.Errorf("expected no body, got %d bytes", rr.Body.Len())
+				}
+				return
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.wants.contentType {
+				t.Errorf("unexpected content type, want %q, got %q", tt.wants.contentType, got)
+			}
+			cfg, _, err := image.DecodeConfig(rr.Body)
+			if err != nil {
+				t.Fatalf("failed to decode image: %v", err)
+			}
+			if cfg.Width != tt.wants.width {
+				t.Errorf("unexpected width, want %d, got %d", tt.wants.width, cfg.Width)
+			}
+		})
+	}
+}
+
+func TestItemImagesE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	itemRepo := NewItemRepository(db)
+	phoneID, err := NewCategoryRepository(db).GetOrCreate(ctx, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := &Item{Name: "used iPhone 16e", CategoryID: phoneID, Images: []*ItemImage{{ImageName: "a.jpg"}, {ImageName: "b.jpg"}}}
+	if err := itemRepo.Insert(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+
+	// image is the expected state of an image, which is compared by name
+	type image struct {
+		Name    string
+		IsCover bool
+	}
+	assertImages := func(t *testing.T, wantCover string, want ...image) []*ItemImage {
+		t.Helper()
+		got, err := itemRepo.Select(ctx, item.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotImages []image
+		for i, img := range got.Images {
+			if img.Position != i {
+				t.Errorf("unexpected position of %s, want %d, got %d", img.ImageName, i, img.Position)
+			}
+			gotImages = append(gotImages, image{Name: img.ImageName, IsCover: img.IsCover})
+		}
+		if diff := cmp.Diff(want, gotImages, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("unexpected images (-want +got):\n%s", diff)
+		}
+		if got.ImageName != wantCover {
+			t.Errorf("unexpected cover image name, want %q, got %q", wantCover, got.ImageName)
+		}
+		return got.Images
+	}
+
+	images := assertImages(t, "a.jpg", image{"a.jpg", true}, image{"b.jpg", false})
+
+	if err := itemRepo.AddImages(ctx, item.ID, []string{"c.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	images = assertImages(t, "a.jpg", image{"a.jpg", true}, image{"b.jpg", false}, image{"c.jpg", false})
+
+	if err := itemRepo.AddImages(ctx, item.ID, make([]string, maxItemImages-2)); !errors.Is(err, errTooManyImages) {
+		t.Errorf("expected %v, got %v", errTooManyImages, err)
+	}
+
+	a, b, c := images[0].ID, images[1].ID, images[2].ID
+	if err := itemRepo.ReorderImages(ctx, item.ID, []int{c, a, b}, b); err != nil {
+		t.Fatal(err)
+	}
+	assertImages(t, "b.jpg", image{"c.jpg", false}, image{"a.jpg", false}, image{"b.jpg", true})
+
+	for name, ids := range map[string][]int{
+		"missing":    {c, a},
+		"duplicated": {c, a, a},
+		"unknown":    {c, a, b + 100},
+	} {
+		if err := itemRepo.ReorderImages(ctx, item.ID, ids, 0); !errors.Is(err, errInvalidImageOrder) {
+			t.Errorf("expected %v for %s ids, got %v", errInvalidImageOrder, name, err)
+		}
+	}
+
+	// the first remaining image becomes the cover
+	if err := itemRepo.DeleteImage(ctx, item.ID, b); err != nil {
+		t.Fatal(err)
+	}
+	assertImages(t, "c.jpg", image{"c.jpg", true}, image{"a.jpg", false})
+	if err := itemRepo.DeleteImage(ctx, item.ID, b); !errors.Is(err, errItemImageNotFound) {
+		t.Errorf("expected %v, got %v", errItemImageNotFound, err)
+	}
+
+	if err := itemRepo.ReplaceImages(ctx, item.ID, []string{"d.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	assertImages(t, "d.jpg", image{"d.jpg", true})
+
+	if err := itemRepo.AddImages(ctx, item.ID+100, []string{"e.jpg"}); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected %v, got %v", errItemNotFound, err)
+	}
+
+	if err := itemRepo.Delete(ctx, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM item_images WHERE item_id = ?`, item.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected the images to be deleted with the item, %d remain", count)
+	}
+}
+
+func TestReorderItemImages(t *testing.T) {
+	t.Parallel()
+
+	owned := &Item{ID: 1, SellerID: &testSeller.ID}
+	cases := map[string]struct {
+		body     string
+		injector func(m *MockItemRepository)
+		wantCode int
+	}{
+		"ok: reordered": {
+			body: `{"image_ids": [2, 1], "cover_image_id": 2}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(owned, nil)
+				m.EXPECT().ReorderImages(gomock.Any(), 1, []int{2, 1}, 2).Return(nil)
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, SellerID: &testSeller.ID, ImageName: "b.jpg", Images: []*ItemImage{
+					{ID: 2, ImageName: "b.jpg", Position: 0, IsCover: true},
+					{ID: 1, ImageName: "a.jpg", Position: 1},
+				}}, nil)
+			},
+			wantCode: http.StatusOK,
+		},
+		"ng: invalid order": {
+			body: `{"image_ids": [2, 2]}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(owned, nil)
+				m.EXPECT().ReorderImages(gomock.Any(), 1, []int{2, 2}, 0).Return(errInvalidImageOrder)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: unknown cover": {
+			body: `{"image_ids": [2, 1], "cover_image_id": 3}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(owned, nil)
+				m.EXPECT().ReorderImages(gomock.Any(), 1, []int{2, 1}, 3).Return(errItemImageNotFound)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ng: not the seller": {
+			body: `{"image_ids": [2, 1]}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, SellerID: &otherSeller.ID}, nil)
+			},
+			wantCode: http.StatusForbidden,
+		},
+		"ng: invalid body": {
+			body:     `[2, 1]`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: unknown field": {
+			body:     `{"image_ids": [2, 1], "cover": 2}`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: too large body": {
+			body:     `{"image_ids": [` + strings.Repeat("1, ", maxJSONBodyBytes/3) + `1]}`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			mockRR, mockAR := newUserRoles(ctrl)
+			h := &Handlers{itemRepo: mockIR, roleRepo: mockRR, auditRepo: mockAR, images: NewMemoryImageStore()}
+
+			req := httptest.NewRequest("PUT", "/items/1/images/order", strings.NewReader(tt.body))
+			req.SetPathValue("id", "1")
+			req = withUser(req, testSeller)
+			rr := httptest.NewRecorder()
+			h.ReorderItemImages(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestItemImagesHandlersE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	h := &Handlers{
+		images:       NewMemoryImageStore(),
+		itemRepo:     NewItemRepository(db),
+		categoryRepo: NewCategoryRepository(db),
+		roleRepo:     NewRoleRepository(db),
+		auditRepo:    NewAuditRepository(db),
+	}
+
+	// newForm builds a multipart form with n distinct images
+	newForm := func(t *testing.T, fields map[string]string, n int) (*bytes.Buffer, string) {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		for k, v := range fields {
+			if err := w.WriteField(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := range n {
+			fw, err := w.CreateFormFile("image", fmt.Sprintf("%d.png", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(encodeTestImage(t, "png", i+1, 1))
+		}
+		w.Close()
+		return &b, w.FormDataContentType()
+	}
+	decodeItem := func(t *testing.T, rr *httptest.ResponseRecorder) *ItemV1 {
+		var item ItemV1
+		if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		return &item
+	}
+
+	fields := map[string]string{"name": "jacket", "category": "fashion", "price": "4800", "condition": "good"}
+	body, contentType := newForm(t, fields, maxItemImages+1)
+	req := httptest.NewRequest("POST", "/items", body)
+	req.Header.Set("Content-Type", contentType)
+	req = withUser(req, testSeller)
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for too many images, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	body, contentType = newForm(t, fields, 2)
+	req = httptest.NewRequest("POST", "/items", body)
+	req.Header.Set("Content-Type", contentType)
+	req = withUser(req, testSeller)
+	rr = httptest.NewRecorder()
+	h.AddItem(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+	item := decodeItem(t, rr)
+	if len(item.Images) != 2 || !item.Images[0].IsCover || item.ImageURL != item.Images[0].URL {
+		t.Fatalf("unexpected images of the added item: %+v", item.Images)
+	}
+
+	body, contentType = newForm(t, nil, 3)
+	req = httptest.NewRequest("POST", fmt.Sprintf("/items/%d/images", item.ID), body)
+	req.Header.Set("Content-Type", contentType)
+	req.SetPathValue("id", strconv.Itoa(item.ID))
+	req = withUser(req, testSeller)
+	rr = httptest.NewRecorder()
+	h.AddItemImages(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+	// the same images as the first two are stored once, but listed again
+	if item = decodeItem(t, rr); len(item.Images) != 5 {
+		t.Fatalf("expected 5 images, got %d", len(item.Images))
+	}
+
+	cover := item.Images[0]
+	deleteCover := func(user *User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/items/%d/images/%d", item.ID, cover.ID), nil)
+		req.SetPathValue("id", strconv.Itoa(item.ID))
+		req.SetPathValue("imageID", strconv.Itoa(cover.ID))
+		req = withUser(req, user)
+		rr := httptest.NewRecorder()
+		h.DeleteItemImage(rr, req)
+		return rr
+	}
+	if rr = deleteCover(otherSeller); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d for another user, got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr = deleteCover(testSeller); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/items/%d", item.ID), nil)
+	req.SetPathValue("id", strconv.Itoa(item.ID))
+	rr = httptest.NewRecorder()
+	h.GetItem(rr, req)
+	item = decodeItem(t, rr)
+	if len(item.Images) != 4 || !item.Images[0].IsCover || item.Images[0].ID == cover.ID {
+		t.Errorf("unexpected images after deleting the cover: %+v", item.Images)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body     string
+		injector func(u *MockUserRepository)
+		code     int
+		// wantFields are the fields reported as invalid.
+		wantFields []string
+	}{
+		"ok: registered": {
+			body: `{"email": "Alice@example.com", "name": "alice", "password": "correct horse"}`,
+			injector: func(u *MockUserRepository) {
+				u.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, user *User) error {
+					if user.PasswordHash == "" || strings.Contains(user.PasswordHash, "correct horse") {
+						t.Errorf("expected the password to be hashed, got %q", user.PasswordHash)
+					}
+					user.ID = 1
+					user.Email = strings.ToLower(user.Email)
+					return nil
+				})
+			},
+			code: http.StatusCreated,
+		},
+		"ng: email already registered": {
+			body: `{"email": "alice@example.com", "name": "alice", "password": "correct horse"}`,
+			injector: func(u *MockUserRepository) {
+				u.EXPECT().Create(gomock.Any(), gomock.Any()).Return(errUserExists)
+			},
+			code: http.StatusConflict,
+		},
+		"ng: invalid fields": {
+			body:       `{"email": "Alice <alice@example.com>", "name": "", "password": "short"}`,
+			injector:   func(u *MockUserRepository) {},
+			code:       http.StatusUnprocessableEntity,
+			wantFields: []string{"email", "name", "password"},
+		},
+		"ng: broken json": {
+			body:     `{"email": `,
+			injector: func(u *MockUserRepository) {},
+			code:     http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockUR := NewMockUserRepository(ctrl)
+			tt.injector(mockUR)
+
+			h := &Handlers{userRepo: mockUR}
+
+			req := httptest.NewRequest("POST", "/auth/register", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.Register(rr, req)
+
+			if tt.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if strings.Contains(rr.Body.String(), "password_hash") || strings.Contains(rr.Body.String(), "argon2id") {
+				t.Errorf("expected the password hash not to be returned, got %s", rr.Body.String())
+			}
+			if tt.wantFields != nil {
+				var resp struct {
+					Details []FieldError `json:"details"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				var fields []string
+				for _, fe := range resp.Details {
+					fields = append(fields, fe.Field)
+				}
+				if diff := cmp.Diff(tt.wantFields, fields); diff != "" {
+					t.Errorf("unexpected invalid fields (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &User{ID: 1, Email: "alice@example.com", Name: "alice", PasswordHash: hash}
+	// created is the session created on login, which is only in the ok case
+	var created *Session
+
+	cases := map[string]struct {
+		body     string
+		injector func(u *MockUserRepository, ss *MockSessionRepository)
+		code     int
+	}{
+		"ok: logged in": {
+			body: `{"email": "alice@example.com", "password": "correct horse"}`,
+			injector: func(u *MockUserRepository, ss *MockSessionRepository) {
+				u.EXPECT().GetByEmail(gomock.Any(), "alice@example.com").Return(alice, nil)
+				ss.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, session *Session) error {
+					created = session
+					return nil
+				})
+			},
+			code: http.StatusOK,
+		},
+		"ng: wrong password": {
+			body: `{"email": "alice@example.com", "password": "wrong horse"}`,
+			injector: func(u *MockUserRepository, ss *MockSessionRepository) {
+				u.EXPECT().GetByEmail(gomock.Any(), "alice@example.com").Return(alice, nil)
+			},
+			code: http.StatusUnauthorized,
+		},
+		"ng: unknown email": {
+			body: `{"email": "bob@example.com", "password": "correct horse"}`,
+			injector: func(u *MockUserRepository, ss *MockSessionRepository) {
+				u.EXPECT().GetByEmail(gomock.Any(), "bob@example.com").Return(nil, errUserNotFound)
+			},
+			code: http.StatusUnauthorized,
+		},
+		"ng: missing password": {
+			body:     `{"email": "alice@example.com"}`,
+			injector: func(u *MockUserRepository, ss *MockSessionRepository) {},
+			code:     http.StatusUnprocessableEntity,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockUR := NewMockUserRepository(ctrl)
+			mockSR := NewMockSessionRepository(ctrl)
+			tt.injector(mockUR, mockSR)
+
+			h := &Handlers{userRepo: mockUR, sessionRepo: mockSR, accessTokenTTL: 15 * time.Minute, refreshTokenTTL: time.Hour}
+
+			req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.Login(rr, req)
+
+			if tt.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp LoginResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(newUserV1(alice), resp.User); diff != "" {
+				t.Errorf("unexpected user (-want +got):\n%s", diff)
+			}
+			if resp.TokenType != "Bearer" || resp.ExpiresIn != 900 {
+				t.Errorf("unexpected token type %q or expiry %d", resp.TokenType, resp.ExpiresIn)
+			}
+			if created.UserID != alice.ID || created.FamilyID == "" ||
+				created.AccessTokenHash != hashToken(resp.AccessToken) || created.RefreshTokenHash != hashToken(resp.RefreshToken) {
+				t.Errorf("unexpected session %+v for the tokens", created)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body     string
+		injector func(ss *MockSessionRepository)
+		code     int
+	}{
+		"ok: refreshed": {
+			body: `{"refresh_token": "refresh"}`,
+			injector: func(ss *MockSessionRepository) {
+				ss.EXPECT().Rotate(gomock.Any(), hashToken("refresh"), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			code: http.StatusOK,
+		},
+		"ng: unknown or expired token": {
+			body: `{"refresh_token": "refresh"}`,
+			injector: func(ss *MockSessionRepository) {
+				ss.EXPECT().Rotate(gomock.Any(), hashToken("refresh"), gomock.Any(), gomock.Any()).Return(errSessionNotFound)
+			},
+			code: http.StatusUnauthorized,
+		},
+		"ng: reused token": {
+			body: `{"refresh_token": "refresh"}`,
+			injector: func(ss *MockSessionRepository) {
+				ss.EXPECT().Rotate(gomock.Any(), hashToken("refresh"), gomock.Any(), gomock.Any()).Return(errRefreshTokenReused)
+			},
+			code: http.StatusUnauthorized,
+		},
+		"ng: missing token": {
+			body:     `{}`,
+			injector: func(ss *MockSessionRepository) {},
+			code:     http.StatusUnprocessableEntity,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockSR := NewMockSessionRepository(ctrl)
+			tt.injector(mockSR)
+
+			h := &Handlers{sessionRepo: mockSR, accessTokenTTL: 15 * time.Minute, refreshTokenTTL: time.Hour}
+
+			req := httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.Refresh(rr, req)
+
+			if tt.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp TokenResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.AccessToken == "" || resp.RefreshToken == "" || resp.RefreshToken == "refresh" {
+				t.Errorf("expected new tokens, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	mockSR := NewMockSessionRepository(ctrl)
+	mockSR.EXPECT().RevokeFamily(gomock.Any(), "family").Return(nil)
+
+	h := &Handlers{sessionRepo: mockSR}
+
+	req := httptest.NewRequest("POST", "/auth/logout", nil)
+	req = req.WithContext(withAuth(req.Context(), &User{ID: 1}, &Session{ID: 2, UserID: 1, FamilyID: "family"}))
+	rr := httptest.NewRecorder()
+	h.Logout(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	alice := &User{ID: 1, Email: "alice@example.com", Name: "alice"}
+	live := &Session{ID: 2, UserID: alice.ID, FamilyID: "family", AccessExpiresAt: time.Now().Add(time.Hour)}
+	expired := &Session{ID: 3, UserID: alice.ID, FamilyID: "family", AccessExpiresAt: time.Now().Add(-time.Second)}
+
+	cases := map[string]struct {
+		header   string
+		injector func(ss *MockSessionRepository, u *MockUserRepository)
+		code     int
+		// wantCode is the error code in the response.
+		wantCode string
+	}{
+		"ok: valid token": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(live, nil)
+				u.EXPECT().GetByID(gomock.Any(), alice.ID).Return(alice, nil)
+			},
+			code: http.StatusOK,
+		},
+		"ng: no token": {
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {},
+			code:     http.StatusUnauthorized,
+			wantCode: codeUnauthorized,
+		},
+		"ng: other scheme": {
+			header:   "Basic YWxpY2U6cGFzc3dvcmQ=",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {},
+			code:     http.StatusUnauthorized,
+			wantCode: codeUnauthorized,
+		},
+		"ng: unknown, rotated or revoked token": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(nil, errSessionNotFound)
+			},
+			code:     http.StatusUnauthorized,
+			wantCode: codeInvalidToken,
+		},
+		"ng: expired token": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(expired, nil)
+			},
+			code:     http.StatusUnauthorized,
+			wantCode: codeInvalidToken,
+		},
+		"ng: database error": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(nil, errors.New("database is locked"))
+			},
+			code:     http.StatusInternalServerError,
+			wantCode: codeInternalError,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockSR := NewMockSessionRepository(ctrl)
+			mockUR := NewMockUserRepository(ctrl)
+			tt.injector(mockSR, mockUR)
+
+			var gotUser *User
+			var gotSession *Session
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser = userFromContext(r.Context())
+				gotSession = sessionFromContext(r.Context())
+			})
+			req := httptest.NewRequest("POST", "/items", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			authMiddleware(next, mockSR, mockUR).ServeHTTP(rr, req)
+
+			if tt.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code == http.StatusOK {
+				if gotUser != alice || gotSession != live {
+					t.Errorf("expected the user and the session in the context, got %+v and %+v", gotUser, gotSession)
+				}
+				return
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("expected error code %q, got %q", tt.wantCode, resp.Code)
+			}
+			if tt.code == http.StatusUnauthorized && !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("expected the WWW-Authenticate header, got %q", rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestOptionalAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	alice := &User{ID: 1, Email: "alice@example.com", Name: "alice"}
+	live := &Session{ID: 2, UserID: alice.ID, FamilyID: "family", AccessExpiresAt: time.Now().Add(time.Hour)}
+
+	cases := map[string]struct {
+		header   string
+		injector func(ss *MockSessionRepository, u *MockUserRepository)
+		code     int
+		wantUser *User
+		// wantErr is the error of authentication passed to the next handler.
+		wantErr error
+	}{
+		"ok: valid token": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(live, nil)
+				u.EXPECT().GetByID(gomock.Any(), alice.ID).Return(alice, nil)
+			},
+			code:     http.StatusOK,
+			wantUser: alice,
+		},
+		"ok: no token is passed as anonymous": {
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {},
+			code:     http.StatusOK,
+			wantErr:  errUnauthenticated,
+		},
+		"ok: invalid token is passed as anonymous": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(nil, errSessionNotFound)
+			},
+			code:    http.StatusOK,
+			wantErr: errInvalidToken,
+		},
+		"ng: database error": {
+			header: "Bearer access",
+			injector: func(ss *MockSessionRepository, u *MockUserRepository) {
+				ss.EXPECT().GetByAccessToken(gomock.Any(), hashToken("access")).Return(nil, errors.New("database is locked"))
+			},
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockSR := NewMockSessionRepository(ctrl)
+			mockUR := NewMockUserRepository(ctrl)
+			tt.injector(mockSR, mockUR)
+
+			var gotUser *User
+			var gotErr error
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser = userFromContext(r.Context())
+				if gotUser == nil {
+					gotErr = authErrorFromContext(r.Context())
+				}
+			})
+			req := httptest.NewRequest("PUT", "/users/2/role", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			optionalAuthMiddleware(next, mockSR, mockUR).ServeHTTP(rr, req)
+
+			if tt.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if gotUser != tt.wantUser || !errors.Is(gotErr, tt.wantErr) {
+				t.Errorf("expected the user %v and the error %v, got %v and %v", tt.wantUser, tt.wantErr, gotUser, gotErr)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	userRepo := NewUserRepository(db)
+
+	alice := &User{Email: "Alice@Example.com", Name: "alice", PasswordHash: "hash"}
+	if err := userRepo.Create(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if alice.ID == 0 || alice.CreatedAt.IsZero() || alice.Email != "alice@example.com" || alice.Role != RoleUser {
+		t.Errorf("unexpected created user: %+v", alice)
+	}
+	if err := userRepo.Create(ctx, &User{Email: "ALICE@example.com", Name: "alice2", PasswordHash: "hash"}); !errors.Is(err, errUserExists) {
+		t.Errorf("expected %v on registering the email again, got %v", errUserExists, err)
+	}
+
+	got, err := userRepo.GetByEmail(ctx, "alice@EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(alice, got); diff != "" {
+		t.Errorf("unexpected user (-want +got):\n%s", diff)
+	}
+	if got, err := userRepo.GetByID(ctx, alice.ID); err != nil || got.Email != alice.Email {
+		t.Errorf("unexpected user by id: %+v, %v", got, err)
+	}
+	if _, err := userRepo.GetByEmail(ctx, "bob@example.com"); !errors.Is(err, errUserNotFound) {
+		t.Errorf("expected %v, got %v", errUserNotFound, err)
+	}
+}
+
+func TestSessionRepositoryE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	userRepo := NewUserRepository(db)
+	sessionRepo := NewSessionRepository(db)
+
+	alice := &User{Email: "alice@example.com", Name: "alice", PasswordHash: "hash"}
+	if err := userRepo.Create(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	login := func(t *testing.T, family string) (*Session, *sessionTokens) {
+		t.Helper()
+		session, tokens, err := newSession(now, time.Minute, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.UserID = alice.ID
+		session.FamilyID = family
+		if err := sessionRepo.Create(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		return session, tokens
+	}
+	assertLive := func(t *testing.T, tokens *sessionTokens, want bool) {
+		t.Helper()
+		_, err := sessionRepo.GetByAccessToken(ctx, hashToken(tokens.AccessToken))
+		if want && err != nil {
+			t.Errorf("expected the session to be live, got %v", err)
+		}
+		if !want && !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected %v, got %v", errSessionNotFound, err)
+		}
+	}
+
+	first, firstTokens := login(t, "family-1")
+	got, err := sessionRepo.GetByAccessToken(ctx, hashToken(firstTokens.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(first, got, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("unexpected session (-want +got):\n%s", diff)
+	}
+
+	// refreshing replaces the session with the next one in the same family
+	second, secondTokens, err := newSession(now, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sessionRepo.Rotate(ctx, hashToken(firstTokens.RefreshToken), second, now); err != nil {
+		t.Fatal(err)
+	}
+	if second.ID == 0 || second.UserID != alice.ID || second.FamilyID != "family-1" {
+		t.Errorf("unexpected rotated session: %+v", second)
+	}
+	assertLive(t, firstTokens, false)
+	assertLive(t, secondTokens, true)
+
+	// another login is not affected by reusing the refresh token of the first one
+	_, otherTokens := login(t, "family-2")
+
+	// reusing the refresh token revokes the whole family
+	third, _, err := newSession(now, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sessionRepo.Rotate(ctx, hashToken(firstTokens.RefreshToken), third, now); !errors.Is(err, errRefreshTokenReused) {
+		t.Errorf("expected %v, got %v", errRefreshTokenReused, err)
+	}
+	assertLive(t, secondTokens, false)
+	assertLive(t, otherTokens, true)
+	if err := sessionRepo.Rotate(ctx, hashToken(secondTokens.RefreshToken), third, now); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v for the revoked session, got %v", errSessionNotFound, err)
+	}
+
+	// an expired refresh token cannot be used
+	if err := sessionRepo.Rotate(ctx, hashToken(otherTokens.RefreshToken), third, now.Add(2*time.Hour)); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v for the expired refresh token, got %v", errSessionNotFound, err)
+	}
+	if err := sessionRepo.Rotate(ctx, hashToken("unknown"), third, now); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v for an unknown refresh token, got %v", errSessionNotFound, err)
+	}
+
+	// logout revokes the family
+	if err := sessionRepo.RevokeFamily(ctx, "family-2"); err != nil {
+		t.Fatal(err)
+	}
+	assertLive(t, otherTokens, false)
+}
+
+func TestSellerItemsE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	h := &Handlers{
+		images:       NewMemoryImageStore(),
+		itemRepo:     NewItemRepository(db),
+		categoryRepo: NewCategoryRepository(db),
+		userRepo:     NewUserRepository(db),
+	}
+
+	alice := &User{Email: "alice@example.com", Name: "alice", PasswordHash: "hash"}
+	bob := &User{Email: "bob@example.com", Name: "bob", PasswordHash: "hash"}
+	for _, u := range []*User{alice, bob} {
+		if err := h.userRepo.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	categoryID, err := h.categoryRepo.GetOrCreate(ctx, "fashion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range []*Item{
+		{Name: "jacket", CategoryID: categoryID, SellerID: &alice.ID},
+		{Name: "shirt", CategoryID: categoryID, SellerID: &bob.ID},
+		{Name: "shoes", CategoryID: categoryID, SellerID: &alice.ID},
+		{Name: "legacy", CategoryID: categoryID},
+	} {
+		if err := h.itemRepo.Insert(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]struct {
+		path    string
+		id      int
+		user    *User
+		handler func(w http.ResponseWriter, r *http.Request)
+		code    int
+		want    []string
+	}{
+		"ok: items of a user": {
+			path:    "/users/{id}/items",
+			id:      alice.ID,
+			handler: h.GetUserItems,
+			code:    http.StatusOK,
+			want:    []string{"shoes", "jacket"},
+		},
+		"ok: own items": {
+			path:    "/me/items",
+			user:    bob,
+			handler: h.GetMyItems,
+			code:    http.StatusOK,
+			want:    []string{"shirt"},
+		},
+		"ng: unknown user": {
+			path:    "/users/{id}/items",
+			id:      bob.ID + 1,
+			handler: h.GetUserItems,
+			code:    http.StatusNotFound,
+		},
+		"ng: own items without authentication": {
+			path:    "/me/items",
+			handler: h.GetMyItems,
+			code:    http.StatusUnauthorized,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := strings.Replace(tt.path, "{id}", strconv.Itoa(tt.id), 1)
+			req := httptest.NewRequest("GET", path, nil)
+			req.SetPathValue("id", strconv.Itoa(tt.id))
+			if tt.user != nil {
+				req = withUser(req, tt.user)
+			}
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp GetItemsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, it := range resp.Items {
+				got = append(got, it.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestModerationE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	h := &Handlers{
+		images:       NewMemoryImageStore(),
+		itemRepo:     NewItemRepository(db),
+		categoryRepo: NewCategoryRepository(db),
+		userRepo:     NewUserRepository(db),
+		roleRepo:     NewRoleRepository(db),
+		auditRepo:    NewAuditRepository(db),
+	}
+
+	alice := &User{Email: "alice@example.com", Name: "alice", PasswordHash: "hash"}
+	carol := &User{Email: "carol@example.com", Name: "carol", PasswordHash: "hash"}
+	dave := &User{Email: "dave@example.com", Name: "dave", PasswordHash: "hash"}
+	for _, u := range []*User{alice, carol, dave} {
+		if err := h.userRepo.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	carol.Role, dave.Role = RoleModerator, RoleAdmin
+	if err := h.userRepo.SetRole(ctx, carol.ID, carol.Role); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.userRepo.SetRole(ctx, dave.ID, dave.Role); err != nil {
+		t.Fatal(err)
+	}
+
+	fashionID, err := h.categoryRepo.GetOrCreate(ctx, "fashion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := &Category{Name: "outer", ParentID: &fashionID}
+	if err := h.categoryRepo.Create(ctx, outer); err != nil {
+		t.Fatal(err)
+	}
+	jacket := &Item{Name: "jacket", CategoryID: outer.ID, SellerID: &alice.ID}
+	shirt := &Item{Name: "shirt", CategoryID: fashionID, SellerID: &alice.ID}
+	for _, it := range []*Item{jacket, shirt} {
+		if err := h.itemRepo.Insert(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// serve calls the handler with a JSON body as the user, and returns the response
+	serve := func(handler http.HandlerFunc, method, path string, id int, user *User, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetPathValue("id", strconv.Itoa(id))
+		if user != nil {
+			req = withUser(req, user)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	// itemNames returns the names of the items listed in the response
+	itemNames := func(t *testing.T, rr *httptest.ResponseRecorder) []string {
+		var resp GetItemsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, it := range resp.Items {
+			names = append(names, it.Name)
+		}
+		return names
+	}
+
+	rr := serve(h.SetItemHidden, "PUT", fmt.Sprintf("/items/%d/hidden", jacket.ID), jacket.ID, carol, `{"hidden": true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d on hiding the item, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr = serve(h.GetItem, "GET", fmt.Sprintf("/items/%d", jacket.ID), jacket.ID, nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for the hidden item, got %d", http.StatusNotFound, rr.Code)
+	}
+	if got := itemNames(t, serve(h.GetItems, "GET", "/items", 0, nil, "")); !cmp.Equal(got, []string{"shirt"}) {
+		t.Errorf("expected the hidden item not to be listed, got %v", got)
+	}
+	if got := itemNames(t, serve(h.GetMyItems, "GET", "/me/items", 0, alice, "")); !cmp.Equal(got, []string{"shirt", "jacket"}) {
+		t.Errorf("expected the seller to see the hidden item, got %v", got)
+	}
+	if items, err := h.itemRepo.SearchByKeyword(ctx, "jacket"); err != nil || len(items) != 0 {
+		t.Errorf("expected the hidden item not to be found, got %v, %v", items, err)
+	}
+	if got, err := h.categoryRepo.GetByID(ctx, outer.ID); err != nil || got.ItemCount != 0 {
+		t.Errorf("expected the hidden item not to be counted, got %+v, %v", got, err)
+	}
+	rr = serve(h.SetItemHidden, "PUT", "/items/0/hidden", jacket.ID+shirt.ID, carol, `{"hidden": true}`)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d on hiding an unknown item, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	mergeCases := []struct {
+		source, target int
+		code           int
+	}{
+		{source: fashionID, target: outer.ID, code: http.StatusConflict},
+		{source: outer.ID, target: outer.ID + 1, code: http.StatusNotFound},
+		{source: outer.ID, target: 0, code: http.StatusUnprocessableEntity},
+		{source: outer.ID, target: fashionID, code: http.StatusOK},
+		{source: outer.ID, target: fashionID, code: http.StatusNotFound},
+	}
+	for _, tt := range mergeCases {
+		body := fmt.Sprintf(`{"target_id": %d}`, tt.target)
+		if rr := serve(h.MergeCategory, "POST", fmt.Sprintf("/categories/%d/merge", tt.source), tt.source, carol, body); rr.Code != tt.code {
+			t.Errorf("merge %d into %d: expected status code %d, got %d: %s", tt.source, tt.target, tt.code, rr.Code, rr.Body.String())
+		}
+	}
+	if got, err := h.itemRepo.Select(ctx, jacket.ID); err != nil || got.CategoryID != fashionID {
+		t.Errorf("expected the item to be moved to the target category, got %+v, %v", got, err)
+	}
+
+	roleCases := []struct {
+		id   int
+		user *User
+		body string
+		code int
+	}{
+		{id: alice.ID, user: dave, body: `{"role": "moderator"}`, code: http.StatusOK},
+		{id: alice.ID, user: dave, body: `{"role": "owner"}`, code: http.StatusBadRequest},
+		{id: alice.ID, user: dave, body: `{}`, code: http.StatusUnprocessableEntity},
+		{id: dave.ID, user: dave, body: `{"role": "user"}`, code: http.StatusForbidden},
+		{id: dave.ID + 1, user: dave, body: `{"role": "user"}`, code: http.StatusNotFound},
+	}
+	for _, tt := range roleCases {
+		if rr := serve(h.SetUserRole, "PUT", fmt.Sprintf("/users/%d/role", tt.id), tt.id, tt.user, tt.body); rr.Code != tt.code {
+			t.Errorf("set role of %d to %s: expected status code %d, got %d: %s", tt.id, tt.body, tt.code, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = serve(h.GetUsers, "GET", "/users", 0, dave, "")
+	var resp GetUsersResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string]Role{}
+	for _, u := range resp.Users {
+		roles[u.Name] = u.Role
+	}
+	if diff := cmp.Diff(map[string]Role{"alice": RoleModerator, "carol": RoleModerator, "dave": RoleAdmin}, roles); diff != "" {
+		t.Errorf("unexpected roles of users (-want +got):\n%s", diff)
+	}
+}
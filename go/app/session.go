@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	errUnauthenticated = errors.New("authentication required")
+	// errInvalidToken does not tell whether the token is unknown, expired, rotated or revoked.
+	errInvalidToken = errors.New("invalid or expired token")
+)
+
+// tokenBytes is the number of random bytes in a token, which makes guessing a token impossible.
+const tokenBytes = 32
+
+// newToken returns an opaque random token, which is safe in a URL and a header.
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash of a token stored in the database. A fast hash is enough,
+// because the tokens are random unlike passwords.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionTokens are the tokens of a session given to the client. The session keeps only their hashes.
+type sessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// newSession returns a session with new tokens expiring after the TTLs from now.
+// The caller sets the user and the family, or Rotate takes them over from the current session.
+func newSession(now time.Time, accessTTL, refreshTTL time.Duration) (*Session, *sessionTokens, error) {
+	access, err := newToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := newToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	session := &Session{
+		AccessTokenHash:  hashToken(access),
+		RefreshTokenHash: hashToken(refresh),
+		AccessExpiresAt:  now.Add(accessTTL),
+		RefreshExpiresAt: now.Add(refreshTTL),
+	}
+	return session, &sessionTokens{AccessToken: access, RefreshToken: refresh}, nil
+}
+
+type authKey struct{}
+
+// auth is the user authenticated by the access token and the session of the token,
+// or the error of authentication for an anonymous request let through by optionalAuthMiddleware.
+type auth struct {
+	user    *User
+	session *Session
+	err     error
+}
+
+func withAuth(ctx context.Context, user *User, session *Session) context.Context {
+	return context.WithValue(ctx, authKey{}, &auth{user: user, session: session})
+}
+
+func withAuthError(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, authKey{}, &auth{err: err})
+}
+
+// authErrorFromContext returns why the request is not authenticated, which is errUnauthenticated
+// unless optionalAuthMiddleware found an invalid token.
+func authErrorFromContext(ctx context.Context) error {
+	if a, ok := ctx.Value(authKey{}).(*auth); ok && a.err != nil {
+		return a.err
+	}
+	return errUnauthenticated
+}
+
+// userFromContext returns the user authenticated by authMiddleware, or nil if the request is not authenticated.
+func userFromContext(ctx context.Context) *User {
+	if a, ok := ctx.Value(authKey{}).(*auth); ok {
+		return a.user
+	}
+	return nil
+}
+
+// sessionFromContext returns the session authenticated by authMiddleware, or nil if the request is not authenticated.
+func sessionFromContext(ctx context.Context) *Session {
+	if a, ok := ctx.Value(authKey{}).(*auth); ok {
+		return a.session
+	}
+	return nil
+}
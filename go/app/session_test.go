@@ -0,0 +1,39 @@
+package app
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestNewSession(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	session, tokens, err := newSession(now, 15*time.Minute, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"access": tokens.AccessToken, "refresh": tokens.RefreshToken} {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(b) != tokenBytes {
+			t.Errorf("expected the %s token to be %d bytes in base64url, got %q", name, tokenBytes, token)
+		}
+	}
+	if tokens.AccessToken == tokens.RefreshToken {
+		t.Errorf("expected the tokens to differ, got %q", tokens.AccessToken)
+	}
+	if session.AccessTokenHash != hashToken(tokens.AccessToken) || session.RefreshTokenHash != hashToken(tokens.RefreshToken) {
+		t.Errorf("expected the session to keep the hashes of the tokens, got %+v", session)
+	}
+	if session.AccessTokenHash == tokens.AccessToken {
+		t.Errorf("expected the token not to be stored as is")
+	}
+	if want := now.Add(15 * time.Minute); !session.AccessExpiresAt.Equal(want) {
+		t.Errorf("expected the access token to expire at %s, got %s", want, session.AccessExpiresAt)
+	}
+	if want := now.Add(24 * time.Hour); !session.RefreshExpiresAt.Equal(want) {
+		t.Errorf("expected the refresh token to expire at %s, got %s", want, session.RefreshExpiresAt)
+	}
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var (
+	errRequestTooLarge = errors.New("request body is too large")
+	// errUploadStorage is a failure to save an upload to a temporary file, which is not the client's fault.
+	errUploadStorage = errors.New("failed to save uploaded file")
+)
+
+// maxFormFieldBytes is the maximum size of a form value other than images.
+const maxFormFieldBytes = 64 << 10
+
+// maxFormOverhead is the allowance for the form values and the multipart boundaries in a request body
+// besides the images. It also limits the total size of the form values on their own, because they are
+// kept in memory unlike the images.
+const maxFormOverhead = 1 << 20
+
+// UploadedImage is an image part of a request streamed to a temporary file. The caller must close it.
+type UploadedImage struct {
+	path string
+	Size int64
+	// Hash is the hex-encoded SHA-256 hash of the content.
+	Hash string
+}
+
+// Open opens the temporary file to read the image.
+func (u *UploadedImage) Open() (*os.File, error) {
+	return os.Open(u.path)
+}
+
+// Close removes the temporary file unless it has been moved into an image store.
+func (u *UploadedImage) Close() error {
+	if err := os.Remove(u.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// putUploadedImage stores the uploaded image as name. It is moved into the store with an atomic rename
+// if the store supports that, and copied otherwise. It holds the lock of the image so that ImageGC
+// does not delete it between checking it and deleting it.
+func putUploadedImage(ctx context.Context, store ImageStore, name string, image *UploadedImage) error {
+	unlock := lockImage(name)
+	defer unlock()
+
+	if fileStore, ok := store.(fileImageStore); ok {
+		return fileStore.PutFile(ctx, name, image.path)
+	}
+	f, err := image.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return store.Put(ctx, name, f)
+}
+
+// closeUploads closes all the uploaded images, logging the failures.
+func closeUploads(images []*UploadedImage) {
+	for _, img := range images {
+		if err := img.Close(); err != nil {
+			slog.Warn("failed to remove uploaded file", "error", err)
+		}
+	}
+}
+
+// imageUploader reads multipart forms streaming the image parts to temporary files,
+// so that a large upload never has to fit in memory.
+type imageUploader struct {
+	// dir is the directory of the temporary files. The default temporary directory is used if it is empty.
+	dir    string
+	limits ImageLimits
+}
+
+// uploader returns the imageUploader for the image store. The temporary files are created where
+// the store can rename them into itself if it supports that.
+func (s *Handlers) uploader() (imageUploader, error) {
+	u := imageUploader{limits: s.imageLimits}
+	if store, ok := s.images.(fileImageStore); ok {
+		dir, err := store.TempDir()
+		if err != nil {
+			return imageUploader{}, fmt.Errorf("%w: %w", errUploadStorage, err)
+		}
+		u.dir = dir
+	}
+	return u, nil
+}
+
+// limitRequestBody caps the request body to the given number of images of the maximum size and the form values.
+// The bodies with images are not capped if the size of images is not limited.
+func (s *Handlers) limitRequestBody(w http.ResponseWriter, r *http.Request, images int) {
+	if images > 0 && s.imageLimits.MaxBytes <= 0 {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(images)*s.imageLimits.MaxBytes+maxFormOverhead)
+}
+
+// readForm reads the form values and the repeated image parts of the request.
+// Requests which are not multipart are parsed as URL-encoded forms without images.
+// The form values are limited to maxFormOverhead in total, besides the allowance for the images.
+// On success, the caller must close the returned images.
+func (u imageUploader) readForm(r *http.Request) (values url.Values, images []*UploadedImage, e error) {
+	mr, err := r.MultipartReader()
+	if errors.Is(err, http.ErrNotMultipart) {
+		// the whole body is form values, which ParseForm reads into memory
+		r.Body = http.MaxBytesReader(nil, r.Body, maxFormOverhead)
+		if err := r.ParseForm(); err != nil {
+			return nil, nil, requestBodyError("failed to parse form", err)
+		}
+		return r.PostForm, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse form: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			closeUploads(images)
+		}
+	}()
+
+	values = url.Values{}
+	var valueBytes int64
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return values, images, nil
+		}
+		if err != nil {
+			return nil, images, requestBodyError("failed to parse form", err)
+		}
+
+		switch name := part.FormName(); {
+		case name == "image":
+			if len(images) == maxItemImages {
+				return nil, images, errTooManyImages
+			}
+			img, err := u.upload(part)
+			if err != nil {
+				return nil, images, err
+			}
+			images = append(images, img)
+		case part.FileName() != "":
+			return nil, images, fmt.Errorf("unexpected file in %q", name)
+		default:
+			b, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes+1))
+			if err != nil {
+				return nil, images, requestBodyError("failed to read form value", err)
+			}
+			if len(b) > maxFormFieldBytes {
+				return nil, images, fmt.Errorf("%q must be at most %d bytes", name, maxFormFieldBytes)
+			}
+			// many small values must not add up to the allowance for the images
+			valueBytes += int64(len(name) + len(b))
+			if valueBytes > maxFormOverhead {
+				return nil, images, fmt.Errorf("%w: form values exceed %d bytes", errRequestTooLarge, maxFormOverhead)
+			}
+			values.Add(name, string(b))
+		}
+	}
+}
+
+// upload streams the image part to a temporary file, hashing it on the way.
+func (u imageUploader) upload(part *multipart.Part) (_ *UploadedImage, e error) {
+	f, err := os.CreateTemp(u.dir, ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUploadStorage, err)
+	}
+	img := &UploadedImage{path: f.Name()}
+	defer func() {
+		f.Close()
+		if e != nil {
+			img.Close()
+		}
+	}()
+
+	// read one more byte than the limit to tell whether the image exceeds it
+	var r io.Reader = part
+	if u.limits.MaxBytes > 0 {
+		r = io.LimitReader(part, u.limits.MaxBytes+1)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return nil, fmt.Errorf("%w: %w", errUploadStorage, err)
+		}
+		return nil, requestBodyError("failed to read image", err)
+	}
+	if u.limits.MaxBytes > 0 && n > u.limits.MaxBytes {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", errImageTooLarge, u.limits.MaxBytes)
+	}
+	if n == 0 {
+		return nil, errors.New("image must not be empty")
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errUploadStorage, err)
+	}
+
+	img.Size = n
+	img.Hash = hex.EncodeToString(hash.Sum(nil))
+	return img, nil
+}
+
+// requestBodyError wraps an error of reading the request body, which is errRequestTooLarge
+// if the body exceeds the limit of http.MaxBytesReader.
+func requestBodyError(msg string, err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return fmt.Errorf("%w: exceeds %d bytes", errRequestTooLarge, maxBytesErr.Limit)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newMultipartForm builds a multipart form with the values and the images.
+func newMultipartForm(t *testing.T, values map[string]string, images ...[]byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	for k, v := range values {
+		if err := w.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, image := range images {
+		fw, err := w.CreateFormFile("image", fmt.Sprintf("%d.jpg", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(image)
+	}
+	w.Close()
+	return &b, w.FormDataContentType()
+}
+
+func TestImageUploaderReadForm(t *testing.T) {
+	t.Parallel()
+
+	image := []byte("image data")
+	hash := sha256.Sum256(image)
+
+	cases := map[string]struct {
+		newRequest func(t *testing.T) *http.Request
+		limits     ImageLimits
+		// maxBody is the cap of the request body set by http.MaxBytesReader. 0 means no cap.
+		maxBody    int64
+		wantValues map[string]string
+		wantImages int
+		wantErr    error
+	}{
+		"ok: values and images": {
+			newRequest: func(t *testing.T) *http.Request {
+				body, contentType := newMultipartForm(t, map[string]string{"name": "jacket"}, image, image)
+				req := httptest.NewRequest("POST", "/items", body)
+				req.Header.Set("Content-Type", contentType)
+				return req
+			},
+			limits:     ImageLimits{MaxBytes: int64(len(image))},
+			wantValues: map[string]string{"name": "jacket"},
+			wantImages: 2,
+		},
+		"ok: url-encoded form": {
+			newRequest: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest("POST", "/items", strings.NewReader("name=jacket"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantValues: map[string]string{"name": "jacket"},
+		},
+		"ng: too large image": {
+			newRequest: func(t *testing.T) *http.Request {
+				body, contentType := newMultipartForm(t, nil, image)
+				req := httptest.NewRequest("POST", "/items", body)
+				req.Header.Set("Content-Type", contentType)
+				return req
+			},
+			limits:  ImageLimits{MaxBytes: int64(len(image) - 1)},
+			wantErr: errImageTooLarge,
+		},
+		"ng: too large request": {
+			newRequest: func(t *testing.T) *http.Request {
+				body, contentType := newMultipartForm(t, nil, bytes.Repeat(image, 100))
+				req := httptest.NewRequest("POST", "/items", body)
+				req.Header.Set("Content-Type", contentType)
+				return req
+			},
+			maxBody: 512,
+			wantErr: errRequestTooLarge,
+		},
+		"ng: too large url-encoded form": {
+			newRequest: func(t *testing.T) *http.Request {
+				body := "name=" + strings.Repeat("x", maxFormOverhead)
+				req := httptest.NewRequest("POST", "/items", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantErr: errRequestTooLarge,
+		},
+		"ng: too many images": {
+			newRequest: func(t *testing.T) *http.Request {
+				images := make([][]byte, maxItemImages+1)
+				for i := range images {
+					images[i] = image
+				}
+				body, contentType := newMultipartForm(t, nil, images...)
+				req := httptest.NewRequest("POST", "/items", body)
+				req.Header.Set("Content-Type", contentType)
+				return req
+			},
+			wantErr: errTooManyImages,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := tt.newRequest(t)
+			if tt.maxBody > 0 {
+				req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, tt.maxBody)
+			}
+			dir := t.TempDir()
+			values, images, err := imageUploader{dir: dir, limits: tt.limits}.readForm(req)
+			defer closeUploads(images)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				if files, _ := os.ReadDir(dir); len(files) > 0 {
+					t.Errorf("expected the uploaded files to be removed, got %d files", len(files))
+				}
+				return
+			}
+			for k, v := range tt.wantValues {
+				if got := values.Get(k); got != v {
+					t.Errorf("unexpected value of %s, want %q, got %q", k, v, got)
+				}
+			}
+			if len(images) != tt.wantImages {
+				t.Fatalf("expected %d images, got %d", tt.wantImages, len(images))
+			}
+			for _, img := range images {
+				if img.Hash != hex.EncodeToString(hash[:]) || img.Size != int64(len(image)) {
+					t.Errorf("unexpected uploaded image: %+v", img)
+				}
+			}
+		})
+	}
+}
+
+func TestAddItemTooLarge(t *testing.T) {
+	t.Parallel()
+
+	limits := ImageLimits{MaxBytes: 1 << 10, MaxWidth: 64, MaxHeight: 64}
+	// fillers are form values within the limit of each value, but exceeding the allowance for all of them
+	fillers := map[string]string{"name": "jacket", "category": "fashion", "price": "4800", "condition": "good"}
+	for i := range maxFormOverhead/maxFormFieldBytes + 1 {
+		fillers[fmt.Sprintf("filler%d", i)] = strings.Repeat("x", maxFormFieldBytes)
+	}
+
+	cases := map[string]struct {
+		values   map[string]string
+		images   [][]byte
+		limits   ImageLimits
+		wantCode string
+	}{
+		"ng: too large image": {
+			values:   map[string]string{"name": "jacket", "category": "fashion", "price": "4800", "condition": "good"},
+			images:   [][]byte{bytes.Repeat([]byte{0xff}, int(limits.MaxBytes)+1)},
+			wantCode: codeImageTooLarge,
+		},
+		"ng: too large request": {
+			// every image and value is within the limit, but the request is not
+			values:   fillers,
+			images:   [][]byte{bytes.Repeat([]byte{0xff}, int(limits.MaxBytes))},
+			wantCode: codeRequestTooLarge,
+		},
+		"ng: too large form values": {
+			// the request is within the allowance for the images, but the values are kept in memory
+			values:   fillers,
+			images:   [][]byte{bytes.Repeat([]byte{0xff}, int(limits.MaxBytes))},
+			limits:   ImageLimits{MaxBytes: 1 << 20, MaxWidth: 64, MaxHeight: 64},
+			wantCode: codeRequestTooLarge,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			images := NewLocalImageStore(t.TempDir())
+			h := &Handlers{images: images, imageLimits: limits}
+			if tt.limits.MaxBytes > 0 {
+				h.imageLimits = tt.limits
+			}
+
+			body, contentType := newMultipartForm(t, tt.values, tt.images...)
+			req := httptest.NewRequest("POST", "/items", body)
+			req.Header.Set("Content-Type", contentType)
+			req = withUser(req, testSeller)
+			rr := httptest.NewRecorder()
+			h.AddItem(rr, req)
+
+			if rr.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected status code %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Code != tt.wantCode {
+				t.Errorf("expected a JSON error with code %s, got %q (%v)", tt.wantCode, rr.Body.String(), err)
+			}
+			if got := rr.Header().Get("Connection"); got != "close" {
+				t.Errorf("expected the connection to be closed, got %q", got)
+			}
+			if list, err := images.List(t.Context()); err != nil || len(list) > 0 {
+				t.Errorf("expected nothing to be stored, got %v (%v)", list, err)
+			}
+			tempDir, err := images.TempDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if files, _ := os.ReadDir(tempDir); len(files) > 0 {
+				t.Errorf("expected the uploaded files to be removed, got %d files", len(files))
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Codes of field errors, which tell the rule a field violates.
+const (
+	fieldRequired         = "required"
+	fieldTooLong          = "too_long"
+	fieldTooShort         = "too_short"
+	fieldInvalidFormat    = "invalid_format"
+	fieldOutOfRange       = "out_of_range"
+	fieldInvalidChoice    = "invalid_choice"
+	fieldTooMany          = "too_many"
+	fieldInvalidChars     = "invalid_characters"
+	fieldSurroundingSpace = "surrounding_whitespace"
+	fieldImageDimensions  = "invalid_image_dimensions"
+)
+
+const (
+	// maxItemNameLength is the maximum number of characters in the name of an item.
+	maxItemNameLength = 100
+	// maxItemDescriptionLength is the maximum number of characters in the description of an item.
+	maxItemDescriptionLength = 1000
+	// maxBrandLength is the maximum number of characters in the brand of an item.
+	maxBrandLength = 50
+	// maxItemPrice is the maximum price of an item in yen. A price is never negative, because it has no sign.
+	maxItemPrice = 9_999_999
+	// maxCategoryNameLength is the maximum number of characters in the name of a category.
+	maxCategoryNameLength = 50
+	// maxUserNameLength is the maximum number of characters in the name of a user.
+	maxUserNameLength = 50
+	// maxEmailLength is the maximum length of an email address in RFC 5321.
+	maxEmailLength = 254
+	// minPasswordLength and maxPasswordLength are the limits of the number of characters in a password.
+	// The maximum keeps hashing a password cheap.
+	minPasswordLength = 8
+	maxPasswordLength = 128
+)
+
+// FieldError is a violation of a validation rule by a field of a request.
+type FieldError struct {
+	// Field is the name of the field in the request, such as "name" or "image[1]".
+	// It is empty for a violation by the request as a whole.
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError is all the violations found in a request, which is responded with 422.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// violation is the code and the message of a broken rule. The message follows the name of the field.
+type violation struct {
+	code    string
+	message string
+}
+
+// rule checks a value of a field, and returns the violation or nil if the value is valid.
+type rule[T any] func(value T) *violation
+
+// validator collects the violations of the fields of a request.
+type validator struct {
+	errs []FieldError
+}
+
+// add reports a violation of the field.
+func (v *validator) add(field, code, message string) {
+	msg := message
+	if field != "" {
+		msg = field + " " + message
+	}
+	v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: msg})
+}
+
+// err returns the violations as a ValidationError, or nil if there are none.
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// checkField checks the value of the field against the rules in order, and reports the first violation,
+// so that a missing value is not reported as too short as well.
+func checkField[T any](v *validator, field string, value T, rules ...rule[T]) {
+	for _, r := range rules {
+		if vl := r(value); vl != nil {
+			v.add(field, vl.code, vl.message)
+			return
+		}
+	}
+}
+
+// checkEach checks each element of the repeated field, which is reported as field[i].
+func checkEach[T any](v *validator, field string, values []T, rules ...rule[T]) {
+	for i, value := range values {
+		checkField(v, fmt.Sprintf("%s[%d]", field, i), value, rules...)
+	}
+}
+
+func required(value string) *violation {
+	if value == "" {
+		return &violation{fieldRequired, "is required"}
+	}
+	return nil
+}
+
+// maxLength limits the number of characters, not bytes, so that it is fair to any language.
+func maxLength(n int) rule[string] {
+	return func(value string) *violation {
+		if utf8.RuneCountInString(value) > n {
+			return &violation{fieldTooLong, fmt.Sprintf("must be at most %d characters", n)}
+		}
+		return nil
+	}
+}
+
+func minLength(n int) rule[string] {
+	return func(value string) *violation {
+		if utf8.RuneCountInString(value) < n {
+			return &violation{fieldTooShort, fmt.Sprintf("must be at least %d characters", n)}
+		}
+		return nil
+	}
+}
+
+// email accepts a bare address such as user@example.com, but not a name with an address.
+func email(value string) *violation {
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return &violation{fieldInvalidFormat, "must be an email address"}
+	}
+	return nil
+}
+
+// printable rejects invalid UTF-8 and the characters which are not shown, such as control characters,
+// line breaks and tabs. The space is the only whitespace allowed.
+func printable(value string) *violation {
+	if !utf8.ValidString(value) || strings.IndexFunc(value, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return &violation{fieldInvalidChars, "must consist of printable characters"}
+	}
+	return nil
+}
+
+// printableText is printable for a multi-line text, which allows line breaks as well.
+func printableText(value string) *violation {
+	return printable(strings.ReplaceAll(value, "\n", " "))
+}
+
+func trimmed(value string) *violation {
+	if strings.TrimSpace(value) != value {
+		return &violation{fieldSurroundingSpace, "must not start or end with whitespace"}
+	}
+	return nil
+}
+
+// parsePrice parses a price in yen, which is a decimal integer without a sign or separators.
+func parsePrice(value string) (int, error) {
+	if value == "" || strings.TrimLeft(value, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid price: %q", value)
+	}
+	return strconv.Atoi(value)
+}
+
+func price(value string) *violation {
+	n, err := parsePrice(value)
+	if err != nil {
+		return &violation{fieldInvalidFormat, "must be an integer in yen"}
+	}
+	if n > maxItemPrice {
+		return &violation{fieldOutOfRange, fmt.Sprintf("must be at most %d yen", maxItemPrice)}
+	}
+	return nil
+}
+
+// oneOf accepts only the values, such as the members of an enum.
+func oneOf[T ~string](values ...T) rule[string] {
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		names = append(names, string(v))
+	}
+	return func(value string) *violation {
+		for _, name := range names {
+			if value == name {
+				return nil
+			}
+		}
+		return &violation{fieldInvalidChoice, "must be one of " + strings.Join(names, ", ")}
+	}
+}
+
+func minCount[T any](n int) rule[[]T] {
+	return func(values []T) *violation {
+		if len(values) < n {
+			return &violation{fieldRequired, "is required"}
+		}
+		return nil
+	}
+}
+
+func maxCount[T any](n int) rule[[]T] {
+	return func(values []T) *violation {
+		if len(values) > n {
+			return &violation{fieldTooMany, fmt.Sprintf("must be at most %d", n)}
+		}
+		return nil
+	}
+}
+
+// validImage checks the dimensions of an uploaded image from its header.
+// The size is checked while uploading, because the rest of a too large request is not read,
+// and the format by supportedImages, because an unsupported format is not a violation but 415.
+func validImage(limits ImageLimits) rule[*UploadedImage] {
+	return func(img *UploadedImage) *violation {
+		f, err := img.Open()
+		if err != nil {
+			// the temporary file is ours, so this is not the client's fault and storeImage reports it
+			return nil
+		}
+		defer f.Close()
+		_, err = detectImageFormat(f, img.Size, ImageLimits{MaxWidth: limits.MaxWidth, MaxHeight: limits.MaxHeight})
+		switch {
+		case errors.Is(err, errImageDimensions) && limits.MaxWidth > 0 && limits.MaxHeight > 0:
+			return &violation{fieldImageDimensions, fmt.Sprintf("must be at most %dx%d pixels", limits.MaxWidth, limits.MaxHeight)}
+		case errors.Is(err, errImageDimensions):
+			return &violation{fieldImageDimensions, "has dimensions out of range"}
+		}
+		return nil
+	}
+}
+
+// supportedImages returns errUnsupportedImage if any of the uploaded images is not a JPEG, PNG, GIF
+// or WebP image. The requests check it before reporting the violations so that the 415 wins over the 422.
+func supportedImages(images []*UploadedImage) error {
+	for _, img := range images {
+		f, err := img.Open()
+		if err != nil {
+			continue
+		}
+		_, err = detectImageFormat(f, img.Size, ImageLimits{})
+		f.Close()
+		if errors.Is(err, errUnsupportedImage) {
+			return err
+		}
+	}
+	return nil
+}
+
+// The rules of the fields shared by the requests to add and update items, categories and users.
+var (
+	itemNameRules     = []rule[string]{required, maxLength(maxItemNameLength), printable, trimmed}
+	priceRules        = []rule[string]{required, price}
+	conditionRules    = []rule[string]{required, oneOf(itemConditions...)}
+	descriptionRules  = []rule[string]{maxLength(maxItemDescriptionLength), printableText}
+	brandRules        = []rule[string]{maxLength(maxBrandLength), printable, trimmed}
+	categoryNameRules = []rule[string]{required, maxLength(maxCategoryNameLength), printable, trimmed}
+	userNameRules     = []rule[string]{required, maxLength(maxUserNameLength), printable, trimmed}
+	emailRules        = []rule[string]{required, maxLength(maxEmailLength), email}
+	passwordRules     = []rule[string]{required, minLength(minPasswordLength), maxLength(maxPasswordLength)}
+)
+
+// itemImagesRules are the rules of the repeated image field of items.
+var itemImagesRules = []rule[[]*UploadedImage]{minCount[*UploadedImage](1), maxCount[*UploadedImage](maxItemImages)}
+
+// valueOrZero returns the value p points to, or the zero value if p is nil.
+// It is for checking a field which is optional in partial updates but required otherwise.
+func valueOrZero[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
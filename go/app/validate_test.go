@@ -0,0 +1,172 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStringRules(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value string
+		rules []rule[string]
+		// want is the code of the violation, or empty if the value is valid.
+		want string
+	}{
+		"ok: item name":                    {value: "used iPhone 16e", rules: itemNameRules},
+		"ok: multibyte characters":         {value: strings.Repeat("あ", maxItemNameLength), rules: itemNameRules},
+		"ng: empty":                        {value: "", rules: itemNameRules, want: fieldRequired},
+		"ng: too long":                     {value: strings.Repeat("a", maxItemNameLength+1), rules: itemNameRules, want: fieldTooLong},
+		"ng: too long category":            {value: strings.Repeat("a", maxCategoryNameLength+1), rules: categoryNameRules, want: fieldTooLong},
+		"ng: line break":                   {value: "jacket\nsize M", rules: itemNameRules, want: fieldInvalidChars},
+		"ng: invalid UTF-8":                {value: "jacket\xff", rules: itemNameRules, want: fieldInvalidChars},
+		"ng: leading space":                {value: " jacket", rules: itemNameRules, want: fieldSurroundingSpace},
+		"ng: the first violation is shown": {value: strings.Repeat("\t", maxItemNameLength+1), rules: itemNameRules, want: fieldTooLong},
+		"ok: email":                        {value: "alice@example.com", rules: emailRules},
+		"ng: email with a name":            {value: "Alice <alice@example.com>", rules: emailRules, want: fieldInvalidFormat},
+		"ng: not an email":                 {value: "alice", rules: emailRules, want: fieldInvalidFormat},
+		"ok: password":                     {value: "correct horse", rules: passwordRules},
+		"ng: short password":               {value: "short", rules: passwordRules, want: fieldTooShort},
+		"ng: long password":                {value: strings.Repeat("a", maxPasswordLength+1), rules: passwordRules, want: fieldTooLong},
+		"ok: price":                        {value: "4800", rules: priceRules},
+		"ok: free":                         {value: "0", rules: priceRules},
+		"ok: highest price":                {value: strconv.Itoa(maxItemPrice), rules: priceRules},
+		"ng: price with a sign":            {value: "+4800", rules: priceRules, want: fieldInvalidFormat},
+		"ng: price with a decimal point":   {value: "4800.5", rules: priceRules, want: fieldInvalidFormat},
+		"ng: too high price":               {value: strconv.Itoa(maxItemPrice + 1), rules: priceRules, want: fieldOutOfRange},
+		"ng: overflowing price":            {value: strings.Repeat("9", 30), rules: priceRules, want: fieldInvalidFormat},
+		"ok: condition":                    {value: "like_new", rules: conditionRules},
+		"ng: unknown condition":            {value: "mint", rules: conditionRules, want: fieldInvalidChoice},
+		"ok: description with line breaks": {value: "warm\nsize M", rules: descriptionRules},
+		"ok: empty description":            {value: "", rules: descriptionRules},
+		"ng: description with a tab":       {value: "warm\tsize M", rules: descriptionRules, want: fieldInvalidChars},
+		"ok: no brand":                     {value: "", rules: brandRules},
+		"ng: too long brand":               {value: strings.Repeat("a", maxBrandLength+1), rules: brandRules, want: fieldTooLong},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v := &validator{}
+			checkField(v, "name", tt.value, tt.rules...)
+			var got string
+			if len(v.errs) > 0 {
+				got = v.errs[0].Code
+			}
+			if got != tt.want {
+				t.Errorf("expected violation %q, got %q (%v)", tt.want, got, v.errs)
+			}
+		})
+	}
+}
+
+func TestValidImage(t *testing.T) {
+	t.Parallel()
+
+	limits := ImageLimits{MaxWidth: 32, MaxHeight: 32}
+	cases := map[string]struct {
+		data []byte
+		want string
+		// unsupported is whether supportedImages rejects the image instead of a violation.
+		unsupported bool
+	}{
+		"ok: jpeg":          {data: encodeTestImage(t, "jpeg", 16, 16)},
+		"ok: png":           {data: encodeTestImage(t, "png", 32, 32)},
+		"ng: not an image":  {data: []byte("not an image"), unsupported: true},
+		"ng: too wide":      {data: encodeTestImage(t, "png", 33, 16), want: fieldImageDimensions},
+		"ng: truncated gif": {data: []byte("GIF89a"), unsupported: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "upload")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			img := &UploadedImage{path: path, Size: int64(len(tt.data))}
+
+			var got string
+			if vl := validImage(limits)(img); vl != nil {
+				got = vl.code
+			}
+			if got != tt.want {
+				t.Errorf("expected violation %q, got %q", tt.want, got)
+			}
+			if err := supportedImages([]*UploadedImage{img}); errors.Is(err, errUnsupportedImage) != tt.unsupported {
+				t.Errorf("expected the image to be unsupported: %v, got %v", tt.unsupported, err)
+			}
+		})
+	}
+}
+
+func TestUpdateItemRequestValidate(t *testing.T) {
+	t.Parallel()
+
+	name, empty := "jacket", ""
+	cases := map[string]struct {
+		req     *UpdateItemRequest
+		partial bool
+		want    []FieldError
+	}{
+		"ok: partial update of name": {
+			req:     &UpdateItemRequest{Name: &name},
+			partial: true,
+		},
+		"ok: partial update clearing brand": {
+			req:     &UpdateItemRequest{Brand: &empty},
+			partial: true,
+		},
+		"ng: empty price in partial update": {
+			req:     &UpdateItemRequest{Price: &empty},
+			partial: true,
+			want:    []FieldError{{Field: "price", Code: fieldRequired, Message: "price is required"}},
+		},
+		"ng: no fields in partial update": {
+			req:     &UpdateItemRequest{},
+			partial: true,
+			want: []FieldError{{
+				Code:    fieldRequired,
+				Message: "at least one of name, category, price, condition, description, brand and image is required",
+			}},
+		},
+		"ng: empty name in partial update": {
+			req:     &UpdateItemRequest{Name: &empty},
+			partial: true,
+			want:    []FieldError{{Field: "name", Code: fieldRequired, Message: "name is required"}},
+		},
+		"ng: missing fields in full update": {
+			req: &UpdateItemRequest{Name: &name},
+			want: []FieldError{
+				{Field: "category", Code: fieldRequired, Message: "category is required"},
+				{Field: "price", Code: fieldRequired, Message: "price is required"},
+				{Field: "condition", Code: fieldRequired, Message: "condition is required"},
+				{Field: "image", Code: fieldRequired, Message: "image is required"},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.req.validate(ImageLimits{}, tt.partial)
+			var got []FieldError
+			if err != nil {
+				got = err.(*ValidationError).Errors
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected violations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
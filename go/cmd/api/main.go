@@ -1,20 +1,59 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"mercari-build-training/app"
 	"os"
 )
 
-const (
-	port         = "9001"
-	imageDirPath = "images"
-)
-
 func main() {
 	// This is the entry point of the application.
-	// You don't need to modify this function.
-	os.Exit(app.Server{
-		Port:         port,
-		ImageDirPath: imageDirPath,
-	}.Run())
+	//
+	//	api [flags]                           starts the server
+	//	api migrate [flags] <cmd>             manages the database schema
+	//	api gc-images [flags] [dry-run]       deletes the images no item refers to
+	//	api check-images [flags] [dry-run]    quarantines the images which do not match their hashes
+	//	api set-role [flags] <email> <role>   changes the role of a user, such as to make the first admin
+	args := os.Args[1:]
+	var command string
+	if len(args) > 0 && (args[0] == "migrate" || args[0] == "gc-images" || args[0] == "check-images" || args[0] == "set-role") {
+		command, args = args[0], args[1:]
+	}
+
+	// the subcommands validate only the settings they use
+	validate := (*app.Config).Validate
+	switch command {
+	case "migrate", "set-role":
+		validate = (*app.Config).ValidateDB
+	case "gc-images", "check-images":
+		validate = (*app.Config).ValidateImages
+	}
+
+	cfg, rest, err := app.LoadConfig(args, os.Getenv, validate)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch command {
+	case "migrate":
+		os.Exit(app.RunMigrateCommand(cfg.DBPath, rest, os.Stdout))
+	case "gc-images":
+		os.Exit(app.RunGCImagesCommand(*cfg, rest, os.Stdout))
+	case "check-images":
+		os.Exit(app.RunCheckImagesCommand(*cfg, rest, os.Stdout))
+	case "set-role":
+		os.Exit(app.RunSetRoleCommand(*cfg, rest, os.Stdout))
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "unknown arguments: %v\n", rest)
+		os.Exit(2)
+	}
+
+	os.Exit(app.Server{Config: *cfg}.Run())
 }
@@ -0,0 +1,36 @@
+-- full-text search index over item names and category names.
+-- This file is applied after the migrations only when SQLite is built with FTS5 (go build -tags sqlite_fts5).
+CREATE VIRTUAL TABLE IF NOT EXISTS items_fts USING fts5 (
+    name,
+    category,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+-- keep the index in sync with items
+CREATE TRIGGER IF NOT EXISTS items_fts_after_insert AFTER INSERT ON items BEGIN
+    INSERT INTO items_fts (rowid, name, category)
+    VALUES (new.id, new.name, (SELECT name FROM categories WHERE id = new.category_id));
+END;
+
+CREATE TRIGGER IF NOT EXISTS items_fts_after_update AFTER UPDATE OF name, category_id ON items BEGIN
+    UPDATE items_fts
+    SET name = new.name, category = (SELECT name FROM categories WHERE id = new.category_id)
+    WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS items_fts_after_delete AFTER DELETE ON items BEGIN
+    DELETE FROM items_fts WHERE rowid = old.id;
+END;
+
+-- keep the index in sync with categories
+CREATE TRIGGER IF NOT EXISTS items_fts_after_category_update AFTER UPDATE OF name ON categories BEGIN
+    UPDATE items_fts SET category = new.name
+    WHERE rowid IN (SELECT id FROM items WHERE category_id = new.id);
+END;
+
+-- index items stored before the index existed
+INSERT INTO items_fts (rowid, name, category)
+SELECT i.id, i.name, c.name
+FROM items i
+JOIN categories c ON i.category_id = c.id
+WHERE i.id NOT IN (SELECT rowid FROM items_fts);
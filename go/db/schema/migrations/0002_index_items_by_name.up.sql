@@ -0,0 +1,2 @@
+-- index for listing items by name with a keyset query
+CREATE INDEX IF NOT EXISTS idx_items_name_id ON items (name, id);
@@ -0,0 +1,18 @@
+-- SQLite cannot add a column defaulting to CURRENT_TIMESTAMP, so the table is rebuilt.
+CREATE TABLE items_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    category_id INTEGER NOT NULL,
+    image_name TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+INSERT INTO items_new (id, name, category_id, image_name)
+SELECT id, name, category_id, image_name FROM items;
+
+DROP TABLE items;
+ALTER TABLE items_new RENAME TO items;
+
+CREATE INDEX idx_items_name_id ON items (name, id);
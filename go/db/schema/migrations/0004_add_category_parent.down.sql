@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_items_category_id;
+DROP INDEX IF EXISTS idx_categories_parent_id;
+
+-- SQLite cannot drop a column with a foreign key, so the table is rebuilt.
+CREATE TABLE categories_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);
+
+INSERT INTO categories_new (id, name)
+SELECT id, name FROM categories;
+
+DROP TABLE categories;
+ALTER TABLE categories_new RENAME TO categories;
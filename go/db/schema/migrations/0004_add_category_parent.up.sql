@@ -0,0 +1,8 @@
+-- a category without a parent is a root category
+ALTER TABLE categories ADD COLUMN parent_id INTEGER REFERENCES categories(id);
+
+-- index for walking down the category tree
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
+
+-- index for listing items in categories
+CREATE INDEX idx_items_category_id ON items (category_id);
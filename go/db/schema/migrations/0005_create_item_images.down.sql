@@ -0,0 +1,3 @@
+-- items.image_name still holds the cover images
+DROP INDEX IF EXISTS idx_item_images_item_id_position;
+DROP TABLE IF EXISTS item_images;
@@ -0,0 +1,16 @@
+-- the images of an item in display order. items.image_name keeps the name of the cover image.
+CREATE TABLE item_images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    image_name TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    is_cover BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- index for listing the images of items in order
+CREATE INDEX idx_item_images_item_id_position ON item_images (item_id, position);
+
+-- move the existing images, which become the covers
+INSERT INTO item_images (item_id, image_name, position, is_cover)
+SELECT id, image_name, 0, TRUE FROM items WHERE image_name != '';
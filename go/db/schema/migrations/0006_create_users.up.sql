@@ -0,0 +1,9 @@
+-- registered users. email is stored in lower case so that it identifies a user regardless of the case.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    -- PHC string of the argon2id hash, such as $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+    password_hash TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
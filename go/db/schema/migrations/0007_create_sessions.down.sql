@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sessions_family_id;
+DROP TABLE IF EXISTS sessions;
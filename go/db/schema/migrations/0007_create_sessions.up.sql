@@ -0,0 +1,18 @@
+-- login sessions. The tokens are stored as SHA-256 hashes, so that the database does not leak usable tokens.
+-- Refreshing a session marks it as rotated and creates the next session in the same family.
+-- Reusing the refresh token of a rotated session tells that it was stolen, and the whole family is revoked.
+CREATE TABLE sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    family_id TEXT NOT NULL,
+    access_token_hash TEXT NOT NULL UNIQUE,
+    refresh_token_hash TEXT NOT NULL UNIQUE,
+    access_expires_at DATETIME NOT NULL,
+    refresh_expires_at DATETIME NOT NULL,
+    rotated_at DATETIME,
+    revoked_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- index for revoking the sessions of a family
+CREATE INDEX idx_sessions_family_id ON sessions (family_id);
@@ -0,0 +1,9 @@
+-- the user who listed the item. It is NULL for the items added before accounts, which only admins can change.
+-- It is not a foreign key, so that the column can be dropped without rebuilding items and the search triggers on it.
+ALTER TABLE items ADD COLUMN seller_id INTEGER;
+
+-- index for listing the items of a seller
+CREATE INDEX idx_items_seller_id_id ON items (seller_id, id);
+
+-- the role of the user, which is user or admin
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS audit_events;
+ALTER TABLE items DROP COLUMN hidden_at;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,39 @@
+-- roles of users and the permissions granted to them. users.role is one of roles.name.
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY
+);
+
+CREATE TABLE role_permissions (
+    role TEXT NOT NULL REFERENCES roles(name),
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name) VALUES ('user'), ('moderator'), ('admin');
+
+-- moderators hide listings and merge categories, and admins can do anything
+INSERT INTO role_permissions (role, permission) VALUES
+    ('moderator', 'items:hide'),
+    ('moderator', 'categories:merge'),
+    ('admin', 'items:change_any'),
+    ('admin', 'items:hide'),
+    ('admin', 'categories:manage'),
+    ('admin', 'categories:merge'),
+    ('admin', 'users:manage');
+
+-- the time a moderator hid the item. Hidden items are not listed nor searched.
+ALTER TABLE items ADD COLUMN hidden_at DATETIME;
+
+-- requests denied by the authorization, kept for auditing
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- the authenticated user, which is NULL for an anonymous request
+    user_id INTEGER,
+    -- the method and the path of the request, such as DELETE /items/1
+    action TEXT NOT NULL,
+    permission TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
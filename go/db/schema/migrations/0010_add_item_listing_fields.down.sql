@@ -0,0 +1,4 @@
+ALTER TABLE items DROP COLUMN brand;
+ALTER TABLE items DROP COLUMN description;
+ALTER TABLE items DROP COLUMN condition;
+ALTER TABLE items DROP COLUMN price;
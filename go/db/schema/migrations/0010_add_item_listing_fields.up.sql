@@ -0,0 +1,7 @@
+-- the price in yen and the condition of the item. They are NULL for the items listed before prices.
+ALTER TABLE items ADD COLUMN price INTEGER CHECK (price >= 0);
+ALTER TABLE items ADD COLUMN condition TEXT CHECK (condition IN ('new', 'like_new', 'good', 'fair', 'poor'));
+
+-- the free-text description and the brand of the item, which are empty if not given
+ALTER TABLE items ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE items ADD COLUMN brand TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- index for listing items by price with a keyset query
+CREATE INDEX IF NOT EXISTS idx_items_price_id ON items (price, id);
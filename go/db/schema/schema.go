@@ -0,0 +1,15 @@
+// Package schema provides the database schema of the application.
+package schema
+
+import "embed"
+
+// Migrations holds the versioned migrations named <version>_<name>.up.sql and <version>_<name>.down.sql .
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// SearchIndex creates the FTS5 full-text search index over items and the triggers keeping it in sync.
+// It is not a versioned migration because it is applied only when SQLite is built with FTS5.
+//
+//go:embed items_fts.sql
+var SearchIndex string